@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/startdusk/greenlight/internal/data"
+	"github.com/startdusk/greenlight/internal/validator"
+)
+
+// A batchOperationInput is one operation in the body of a batch request. The id and
+// version fields are used by updates and deletes, and the movie fields by creates and
+// updates. Updates replace every editable field of the movie.
+type batchOperationInput struct {
+	Op      string       `json:"op"`
+	ID      int64        `json:"id"`
+	Version int          `json:"version"`
+	Title   string       `json:"title"`
+	Year    int          `json:"year"`
+	Runtime data.Runtime `json:"runtime"`
+	Genres  []string     `json:"genres"`
+}
+
+// A batchResult reports the outcome of one operation in a batch, using the status code
+// the same request would have got on its own.
+type batchResult struct {
+	Op     string      `json:"op"`
+	Status int         `json:"status"`
+	ID     int64       `json:"id,omitempty"`
+	Movie  *data.Movie `json:"movie,omitempty"`
+	Error  any         `json:"error,omitempty"`
+}
+
+// errInvalidOperation marks operations which failed validation, so that Batch() skips
+// them in best-effort mode.
+var errInvalidOperation = errors.New("invalid operation")
+
+// The batchMoviesHandler creates, updates and deletes movies in one transaction. By
+// default the batch is atomic: if any operation fails, none of them are saved. With
+// atomic=false each operation succeeds or fails on its own, and the results say which
+// is which.
+func (app *application) batchMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Operations []batchOperationInput `json:"operations"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	atomic := app.readBool(r.URL.Query(), "atomic", true, v)
+	v.Check(len(input.Operations) > 0, "operations", "must contain at least 1 operation")
+	v.Check(len(input.Operations) <= 100, "operations", "must not contain more than 100 operations")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ops := make([]*data.MovieOperation, len(input.Operations))
+	errs := make(map[int]map[string]string)
+
+	for i, in := range input.Operations {
+		op := &data.MovieOperation{
+			Op: in.Op,
+			Movie: &data.Movie{
+				ID:      in.ID,
+				Version: in.Version,
+				Title:   in.Title,
+				Year:    in.Year,
+				Runtime: in.Runtime,
+				Genres:  in.Genres,
+			},
+		}
+		ops[i] = op
+
+		v := validator.New()
+		v.Check(validator.PermittedValue(in.Op, data.MovieOperations...), "op", "must be one of create, update or delete")
+		switch in.Op {
+		case "create":
+			data.ValidateMovie(v, op.Movie)
+		case "update":
+			v.Check(in.ID > 0, "id", "must be provided")
+			v.Check(in.Version > 0, "version", "must be provided")
+			data.ValidateMovie(v, op.Movie)
+		case "delete":
+			v.Check(in.ID > 0, "id", "must be provided")
+			v.Check(in.Version >= 0, "version", "must not be negative")
+		}
+
+		if !v.Valid() {
+			errs[i] = v.Errors
+			op.Err = errInvalidOperation
+		}
+	}
+
+	if atomic && len(errs) > 0 {
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, errs)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.Movies.Batch(ops, user.ID, atomic)
+	if err != nil && !errors.Is(err, data.ErrBatchAborted) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var changed []int64
+	for _, op := range ops {
+		if op.Done && op.Op != "create" {
+			changed = append(changed, op.Movie.ID)
+		}
+	}
+	app.forgetSimilarMovies(changed...)
+
+	results := make([]batchResult, len(ops))
+	for i, op := range ops {
+		result := batchResult{Op: op.Op, ID: op.Movie.ID}
+
+		switch {
+		case op.Done && op.Op == "create":
+			result.Status = http.StatusCreated
+			result.Movie = op.Movie
+		case op.Done && op.Op == "update":
+			result.Status = http.StatusOK
+			result.Movie = op.Movie
+		case op.Done:
+			result.Status = http.StatusOK
+		case errors.Is(op.Err, errInvalidOperation):
+			result.Status = http.StatusUnprocessableEntity
+			result.Error = errs[i]
+		case errors.Is(op.Err, data.ErrRecordNotFound):
+			result.Status = http.StatusNotFound
+			result.Error = "the requested resource could not be found"
+		case errors.Is(op.Err, data.ErrEditConflict):
+			result.Status = http.StatusConflict
+			result.Error = "unable to update the record due to an edit conflict, please try again"
+		default:
+			// Only possible when an atomic batch was rolled back because of another
+			// operation.
+			result.Status = http.StatusFailedDependency
+			result.Error = "not applied because another operation in the batch failed"
+		}
+
+		// A create that didn't happen has no movie ID to report.
+		if op.Op == "create" && !op.Done {
+			result.ID = 0
+		}
+
+		results[i] = result
+	}
+
+	if errors.Is(err, data.ErrBatchAborted) {
+		msg := "the batch was not applied because one of its operations failed"
+		err = app.writeJSON(w, http.StatusConflict, envelope{"error": msg, "results": results}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"results": results}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"strings"
 )
 
 // The logError() method is a generic helper for logging an error message. Later in the
@@ -70,6 +71,11 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
 	app.errorResponse(w, r, http.StatusConflict, msg)
 }
 
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	msg := "the resource doesn't match the request's preconditions, it may have changed since you last retrieved it"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, msg)
+}
+
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
 	msg := "rate limit exceeded"
 	app.errorResponse(w, r, http.StatusTooManyRequests, msg)
@@ -100,3 +106,13 @@ func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Requ
 	msg := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, msg)
 }
+
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, supported ...string) {
+	msg := fmt.Sprintf("the request body must have one of the following content types: %s", strings.Join(supported, ", "))
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, msg)
+}
+
+func (app *application) notAcceptableResponse(w http.ResponseWriter, r *http.Request, supported ...string) {
+	msg := fmt.Sprintf("the resource can only be returned in the following formats: %s", strings.Join(supported, ", "))
+	app.errorResponse(w, r, http.StatusNotAcceptable, msg)
+}
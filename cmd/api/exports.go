@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/startdusk/greenlight/internal/data"
+	"github.com/startdusk/greenlight/internal/validator"
+)
+
+// The formats the catalogue export can be returned in, mapped to their media types.
+var exportFormats = map[string]string{
+	"csv":    "text/csv",
+	"ndjson": "application/x-ndjson",
+	"json":   "application/json",
+}
+
+// The exportMoviesHandler streams every movie matching the same filters as the list
+// endpoint to the client. The format is picked by the format query string parameter or, failing
+// that, the Accept header, and defaults to JSON.
+func (app *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Filter data.MovieFilter
+		Format string
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+	input.Filter = app.readMovieFilter(qs, v)
+	input.Format = app.readString(qs, "format", "")
+	if input.Format != "" {
+		_, ok := exportFormats[input.Format]
+		v.Check(ok, "format", "must be one of csv, ndjson or json")
+	}
+	if data.ValidateMovieFilter(v, input.Filter); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if input.Format == "" {
+		var ok bool
+		input.Format, ok = negotiateExportFormat(r.Header.Get("Accept"))
+		if !ok {
+			app.notAcceptableResponse(w, r, "text/csv", "application/x-ndjson", "application/json")
+			return
+		}
+	}
+
+	// The export can take far longer than the server's WriteTimeout, so we push the
+	// write deadline back each time another batch of movies has been sent.
+	rc := http.NewResponseController(w)
+	extendDeadline := func() error {
+		err := rc.Flush()
+		if err != nil {
+			return err
+		}
+		return rc.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	}
+
+	encoder := newMovieExportEncoder(w, input.Format)
+
+	// Nothing is written until the first movie arrives (or the export finishes with no
+	// movies), so that we can still send a normal error response if the query fails.
+	started := false
+	start := func() error {
+		started = true
+		w.Header().Set("Content-Type", exportFormats[input.Format])
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="movies.%s"`, input.Format))
+		w.WriteHeader(http.StatusOK)
+		return encoder.begin()
+	}
+
+	count := 0
+	err := app.models.Movies.Export(r.Context(), input.Filter, func(movie *data.Movie) error {
+		if !started {
+			err := start()
+			if err != nil {
+				return err
+			}
+		}
+
+		err := encoder.encode(movie)
+		if err != nil {
+			return err
+		}
+
+		count++
+		if count%1000 == 0 {
+			return extendDeadline()
+		}
+		return nil
+	})
+	if err == nil && !started {
+		err = start()
+	}
+	if err == nil {
+		err = encoder.end()
+	}
+	if err != nil {
+		// Once the response has started there's no way to send an error to the client,
+		// so the best we can do is log it. The client will see a truncated body.
+		if !started {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.logError(r, err)
+	}
+}
+
+// The negotiateExportFormat() function picks the export format that best matches an
+// Accept header, honouring q-values. An empty header, or one that accepts anything,
+// gets JSON.
+func negotiateExportFormat(accept string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return "json", true
+	}
+
+	type candidate struct {
+		format string
+		q      float64
+	}
+	var candidates []candidate
+
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(s, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+
+		switch mediaType {
+		case "*/*", "application/*":
+			candidates = append(candidates, candidate{"json", q})
+		case "text/*":
+			candidates = append(candidates, candidate{"csv", q})
+		default:
+			for format, exportType := range exportFormats {
+				if mediaType == exportType {
+					candidates = append(candidates, candidate{format, q})
+				}
+			}
+		}
+	}
+
+	if len(candidates) == 0 {
+		return "", false
+	}
+
+	// A stable sort keeps the order from the header for media types with the same
+	// q-value.
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+
+	return candidates[0].format, true
+}
+
+// A movieExportEncoder writes a stream of movies in one of the export formats.
+type movieExportEncoder struct {
+	begin  func() error
+	encode func(*data.Movie) error
+	end    func() error
+}
+
+func newMovieExportEncoder(w io.Writer, format string) movieExportEncoder {
+	switch format {
+	case "csv":
+		// The CSV columns use the same conventions as the import endpoint, so an
+		// export can be fed straight back in.
+		cw := csv.NewWriter(w)
+		return movieExportEncoder{
+			begin: func() error {
+				return cw.Write([]string{"id", "title", "year", "runtime", "genres", "version", "average_rating", "rating_count"})
+			},
+			encode: func(movie *data.Movie) error {
+				return cw.Write([]string{
+					strconv.FormatInt(movie.ID, 10),
+					movie.Title,
+					strconv.Itoa(movie.Year),
+					strconv.Itoa(int(movie.Runtime)),
+					strings.Join(movie.Genres, "|"),
+					strconv.Itoa(movie.Version),
+					strconv.FormatFloat(movie.AverageRating, 'f', -1, 64),
+					strconv.Itoa(movie.RatingCount),
+				})
+			},
+			end: func() error {
+				cw.Flush()
+				return cw.Error()
+			},
+		}
+
+	case "ndjson":
+		enc := json.NewEncoder(w)
+		return movieExportEncoder{
+			begin:  func() error { return nil },
+			encode: func(movie *data.Movie) error { return enc.Encode(movie) },
+			end:    func() error { return nil },
+		}
+
+	default:
+		// Write the same {"movies": [...]} envelope as the list endpoint, one movie at
+		// a time.
+		first := true
+		return movieExportEncoder{
+			begin: func() error {
+				_, err := io.WriteString(w, "{\"movies\":[\n")
+				return err
+			},
+			encode: func(movie *data.Movie) error {
+				js, err := json.Marshal(movie)
+				if err != nil {
+					return err
+				}
+				if !first {
+					js = append([]byte(",\n"), js...)
+				}
+				first = false
+				_, err = w.Write(js)
+				return err
+			},
+			end: func() error {
+				_, err := io.WriteString(w, "\n]}\n")
+				return err
+			},
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/startdusk/greenlight/internal/data"
+	"github.com/startdusk/greenlight/internal/validator"
+)
+
+func (app *application) listMovieExternalIDsHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Movies.Get(movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ids, err := app.models.ExternalIDs.GetAllForMovie(movieID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"external_ids": ids}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The replaceMovieExternalIDsHandler replaces the complete set of external IDs for a
+// movie with the one in the request body.
+func (app *application) replaceMovieExternalIDsHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Movies.Get(movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		ExternalIDs data.ExternalIDs `json:"external_ids"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateExternalIDs(v, input.ExternalIDs); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.ExternalIDs.ReplaceForMovie(movieID, input.ExternalIDs)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateExternalID):
+			v.AddError("external_ids", "must not belong to another movie")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"external_ids": input.ExternalIDs}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The lookupMovieHandler finds a movie by the ID another catalogue uses for it, for
+// example "GET /v1/movies/lookup?provider=imdb&id=tt0111161". The fields, expand and
+// lang parameters, and the Accept-Language header, work as they do for the show
+// endpoint.
+func (app *application) lookupMovieHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Provider string
+		ID       string
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+	input.Provider = strings.ToLower(app.readString(qs, "provider", ""))
+	input.ID = strings.TrimSpace(app.readString(qs, "id", ""))
+	selection := app.readFieldSelection(qs, v, movieFields, movieExpansions)
+	languages := app.readLanguages(r, v)
+	v.Check(input.Provider != "", "provider", "must be provided")
+	v.Check(validator.PermittedValue(input.Provider, data.ExternalIDProviders...), "provider", "must be one of "+strings.Join(data.ExternalIDProviders, ", "))
+	v.Check(input.ID != "", "id", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie, err := app.models.Movies.GetByExternalID(input.Provider, input.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.translateMovies([]*data.Movie{movie}, languages)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	embedded, err := app.expandMovies([]*data.Movie{movie}, selection)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Add("Vary", "Accept-Language")
+	if movie.Language != "" {
+		w.Header().Set("Content-Language", movie.Language)
+	}
+
+	headers := make(http.Header)
+	headers.Set("Content-Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+
+	err = app.writeConditionalJSON(w, r, envelope{"movie": selection.apply(movie, embedded[movie.ID])}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
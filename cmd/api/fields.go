@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/startdusk/greenlight/internal/validator"
+)
+
+// A fieldSelection holds the fields and expansions requested with the fields and
+// expand query string parameters.
+type fieldSelection struct {
+	fields []string // The JSON keys to include, or nil for all of them
+	expand []string // The related data to embed
+}
+
+// The readFieldSelection() helper reads the fields and expand query string parameters
+// and checks them against the fields and expansions the endpoint supports.
+func (app *application) readFieldSelection(qs url.Values, v *validator.Validator, fields, expandable []string) fieldSelection {
+	var selection fieldSelection
+
+	selection.fields = app.readCSV(qs, "fields", nil)
+	for _, field := range selection.fields {
+		v.Check(validator.PermittedValue(field, fields...), "fields", "must only contain "+strings.Join(fields, ", "))
+	}
+	v.Check(validator.Unique(selection.fields), "fields", "must not contain duplicate values")
+
+	selection.expand = app.readCSV(qs, "expand", nil)
+	for _, name := range selection.expand {
+		v.Check(validator.PermittedValue(name, expandable...), "expand", "must only contain "+strings.Join(expandable, ", "))
+	}
+	v.Check(validator.Unique(selection.expand), "expand", "must not contain duplicate values")
+
+	return selection
+}
+
+// The expands() method reports whether the named expansion was requested.
+func (s fieldSelection) expands(name string) bool {
+	return validator.PermittedValue(name, s.expand...)
+}
+
+// The apply() method returns value, restricted to the selected fields and with the
+// embedded values added, ready to be put in an envelope. The embedded values are
+// always included, whatever fields were selected.
+func (s fieldSelection) apply(value any, embedded envelope) any {
+	if s.fields == nil && len(embedded) == 0 {
+		return value
+	}
+
+	return jsonObject{value: value, fields: s.fields, embedded: embedded}
+}
+
+// A jsonObject wraps a value which encodes to a JSON object, keeping only some of its
+// keys and adding others.
+type jsonObject struct {
+	value    any
+	fields   []string
+	embedded envelope
+}
+
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	js, err := json.Marshal(o.value)
+	if err != nil {
+		return nil, err
+	}
+
+	// Walk through the encoded object, rather than decoding it into a map, so that the
+	// remaining keys stay in their original order.
+	dec := json.NewDecoder(bytes.NewReader(js))
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if token != json.Delim('{') {
+		return nil, errors.New("field selection can only be applied to JSON objects")
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+
+	writeMember := func(key string, value []byte) error {
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		js, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+		buf.Write(js)
+		buf.WriteByte(':')
+		buf.Write(value)
+		return nil
+	}
+
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key := token.(string)
+
+		var value json.RawMessage
+		err = dec.Decode(&value)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := o.embedded[key]; ok {
+			continue
+		}
+		if o.fields != nil && !validator.PermittedValue(key, o.fields...) {
+			continue
+		}
+
+		err = writeMember(key, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	keys := make([]string, 0, len(o.embedded))
+	for key := range o.embedded {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		js, err := json.Marshal(o.embedded[key])
+		if err != nil {
+			return nil, err
+		}
+
+		err = writeMember(key, js)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,8 +10,10 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/startdusk/greenlight/internal/data"
 	"github.com/startdusk/greenlight/internal/validator"
 )
 
@@ -27,6 +30,39 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+// Retrieve the "version" URL parameter from the current request context, in the same
+// way as readIDParam().
+func (app *application) readVersionParam(r *http.Request) (int, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	version, err := strconv.Atoi(params.ByName("version"))
+	if err != nil || version < 1 {
+		return 0, errors.New("invalid version parameter")
+	}
+	return version, nil
+}
+
+// Retrieve the "movie_id" URL parameter from the current request context, in the same
+// way as readIDParam(), for routes where "id" refers to something else.
+func (app *application) readMovieIDParam(r *http.Request) (int64, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.ParseInt(params.ByName("movie_id"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid movie_id parameter")
+	}
+	return id, nil
+}
+
+// Retrieve the "lang" URL parameter from the current request context, as a canonical
+// BCP 47 language tag.
+func (app *application) readLanguageParam(r *http.Request) (string, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	language := canonicalLanguageTag(params.ByName("lang"))
+	if !validator.Matches(language, data.LanguageTagRX) {
+		return "", errors.New("invalid lang parameter")
+	}
+	return language, nil
+}
+
 func (app *application) writeJSON(w http.ResponseWriter, status int, data any, headers http.Header) error {
 	js, err := json.MarshalIndent(data, "", "\t")
 	if err != nil {
@@ -159,6 +195,63 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, fmt.Sprintf(`key "%s" must be a boolean value`, key))
+		return defaultValue
+	}
+
+	return b
+}
+
+// The readTime() helper reads a time from the query string. Both RFC 3339 timestamps
+// and plain dates (which are taken as midnight UTC) are accepted.
+func (app *application) readTime(qs url.Values, key string, defaultValue time.Time, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t, err = time.Parse(time.DateOnly, s)
+	}
+	if err != nil {
+		v.AddError(key, fmt.Sprintf(`key "%s" must be a date or an RFC 3339 timestamp`, key))
+		return defaultValue
+	}
+
+	return t
+}
+
+// httprouter doesn't allow a fixed path segment in the same position as a named
+// parameter, so a route like "/v1/movies/import" can't be registered alongside
+// "/v1/movies/:id/reviews". Instead we register the fixed routes on the ":id" route and
+// dispatch on the value of the parameter here. Any other value is passed to next, or
+// gets a 404 Not Found response if next is nil.
+func (app *application) fixedRoutes(routes map[string]http.HandlerFunc, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+		if handler, ok := routes[params.ByName("id")]; ok {
+			handler(w, r)
+			return
+		}
+
+		if next == nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
 func (app *application) background(fn func()) {
 	app.wg.Add(1)
 	go func() {
@@ -172,3 +265,93 @@ func (app *application) background(fn func()) {
 		fn()
 	}()
 }
+
+// The movieETag() function returns the entity tag for the full representation of a
+// movie. The version number is bumped on every change to the movie itself, but the
+// rating summary changes with the reviews without touching the version, so it is part
+// of the tag too.
+func movieETag(movie *data.Movie) string {
+	return fmt.Sprintf(`"%d-%d-%s"`, movie.Version, movie.RatingCount, strconv.FormatFloat(movie.AverageRating, 'f', -1, 64))
+}
+
+// The etagMatches() function reports whether an If-Match or If-None-Match header value,
+// which can hold a comma-separated list of entity tags or "*", matches the given tag.
+// With weak set to true the W/ prefix is ignored on both sides, as If-None-Match
+// requires.
+func etagMatches(header, etag string, weak bool) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+
+	if weak {
+		etag = strings.TrimPrefix(etag, "W/")
+	}
+
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		} else if strings.HasPrefix(candidate, "W/") || strings.HasPrefix(etag, "W/") {
+			continue
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// The checkPreconditions() method evaluates the If-Match and If-None-Match headers of a
+// request against the current entity tag of the resource. If a precondition fails it
+// sends the 304 Not Modified or 412 Precondition Failed response itself and returns
+// false, in which case the handler should return straight away.
+func (app *application) checkPreconditions(w http.ResponseWriter, r *http.Request, etag string) bool {
+	if header := r.Header.Get("If-Match"); header != "" && !etagMatches(header, etag, false) {
+		app.preconditionFailedResponse(w, r)
+		return false
+	}
+
+	if header := r.Header.Get("If-None-Match"); header != "" && etagMatches(header, etag, true) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			w.Header().Set("ETag", etag)
+			w.WriteHeader(http.StatusNotModified)
+		} else {
+			app.preconditionFailedResponse(w, r)
+		}
+		return false
+	}
+
+	return true
+}
+
+// The writeConditionalJSON() method works like writeJSON() with a 200 OK status, but
+// also sends an ETag header holding a hash of the response body and honours the
+// request's conditional headers against it. It's for responses, like lists, which
+// don't have a version number of their own.
+func (app *application) writeConditionalJSON(w http.ResponseWriter, r *http.Request, data any, headers http.Header) error {
+	js, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	js = append(js, '\n')
+
+	sum := sha256.Sum256(js)
+	etag := fmt.Sprintf(`"%x"`, sum[:16])
+
+	if !app.checkPreconditions(w, r, etag) {
+		return nil
+	}
+
+	for key, val := range headers {
+		w.Header()[key] = val
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(http.StatusOK)
+	w.Write(js)
+
+	return nil
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/startdusk/greenlight/internal/data"
+	"github.com/startdusk/greenlight/internal/validator"
+)
+
+// An importRow holds a movie read from an import body, along with the line it started
+// on so that any errors can be reported back against it.
+type importRow struct {
+	line  int
+	movie data.Movie
+}
+
+// How long a client can go without sending any more of an import body, and how long
+// the response can take once the whole body has arrived. The import itself is allowed
+// up to 30 seconds.
+const (
+	importReadTimeout  = 10 * time.Second
+	importWriteTimeout = 40 * time.Second
+)
+
+// A deadlineReader reads a request body, pushing the connection's read deadline back by
+// importReadTimeout before each read.
+type deadlineReader struct {
+	rc   *http.ResponseController
+	body io.ReadCloser
+}
+
+func (d *deadlineReader) Read(p []byte) (int, error) {
+	err := d.rc.SetReadDeadline(time.Now().Add(importReadTimeout))
+	if err != nil {
+		return 0, err
+	}
+	return d.body.Read(p)
+}
+
+func (d *deadlineReader) Close() error {
+	return d.body.Close()
+}
+
+// The rowErrors type maps line numbers in an import body to the errors found on that
+// line, in the same field-to-message format as validator.Validator.
+type rowErrors map[int]map[string]string
+
+// The importMoviesHandler creates movies in bulk from a CSV or JSON Lines body. Every
+// row is validated before anything is written, and the movies are only inserted if the
+// whole batch is valid. Rows with external IDs update the movie which already has one
+// of them, if there is one, so feeds can be imported again safely. With dry_run=true
+// the rows are validated but nothing is inserted.
+func (app *application) importMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	dryRun := app.readBool(r.URL.Query(), "dry_run", false, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Imports are far bigger than the JSON bodies accepted by readJSON(), so they get
+	// their own size limit. They can also take far longer than the server's ReadTimeout
+	// to upload, so the read deadline is pushed back whenever more of the body arrives,
+	// and only a client which stalls is cut off.
+	rc := http.NewResponseController(w)
+	r.Body = http.MaxBytesReader(w, &deadlineReader{rc: rc, body: r.Body}, app.config.importer.maxBytes)
+
+	var rows []importRow
+	errs := make(rowErrors)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var err error
+	switch mediaType {
+	case "text/csv":
+		rows, err = app.readCSVMovies(r.Body, errs)
+	case "application/x-ndjson":
+		rows, err = app.readNDJSONMovies(r.Body, errs)
+	default:
+		app.unsupportedMediaTypeResponse(w, r, "text/csv", "application/x-ndjson")
+		return
+	}
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// The write deadline was set when the request arrived, so push it back too, leaving
+	// time for the import itself on top of the usual 10 seconds.
+	err = rc.SetWriteDeadline(time.Now().Add(importWriteTimeout))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if len(rows) == 0 && len(errs) == 0 {
+		v.AddError("body", "must contain at least one movie")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies := make([]*data.Movie, len(rows))
+	for i := range rows {
+		v := validator.New()
+		if rows[i].movie.ExternalIDs != nil {
+			data.ValidateExternalIDs(v, rows[i].movie.ExternalIDs)
+		}
+		if data.ValidateMovie(v, &rows[i].movie); !v.Valid() {
+			errs[rows[i].line] = v.Errors
+		}
+		movies[i] = &rows[i].movie
+	}
+
+	if len(errs) > 0 {
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, errs)
+		return
+	}
+
+	summary := envelope{"rows": len(movies), "dry_run": dryRun}
+
+	if !dryRun {
+		user := app.contextGetUser(r)
+
+		result, err := app.models.Movies.Import(movies, user.ID)
+		if err != nil {
+			var importErr *data.ImportError
+			switch {
+			case errors.As(err, &importErr) && errors.Is(err, data.ErrExternalIDConflict):
+				errs[rows[importErr.Index].line] = map[string]string{"external_ids": "must not match more than one movie, or a movie in the trash"}
+				app.errorResponse(w, r, http.StatusUnprocessableEntity, errs)
+			case errors.As(err, &importErr) && errors.Is(err, data.ErrDuplicateExternalID):
+				errs[rows[importErr.Index].line] = map[string]string{"external_ids": "must not belong to another movie"}
+				app.errorResponse(w, r, http.StatusUnprocessableEntity, errs)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		// Import fills in the IDs of the movies, but doesn't say which ones changed.
+		if result.Updated > 0 {
+			ids := make([]int64, len(movies))
+			for i, movie := range movies {
+				ids[i] = movie.ID
+			}
+			app.forgetSimilarMovies(ids...)
+		}
+
+		summary["created"] = result.Created
+		summary["updated"] = result.Updated
+		summary["unchanged"] = result.Unchanged
+	}
+
+	status := http.StatusCreated
+	if dryRun {
+		status = http.StatusOK
+	}
+
+	err = app.writeJSON(w, status, envelope{"import": summary}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readCSVMovies() method reads movies from a CSV body. The first record must be a
+// header naming the title, year, runtime and genres columns (in any order). Runtimes
+// are given in minutes, and genres are separated with a "|" character, for example:
+//
+//	title,year,runtime,genres
+//	Moana,2016,107,animation|adventure
+//
+// The optional imdb_id, tmdb_id and eidr_id columns hold the movie's external IDs.
+//
+// Values which can't be converted are recorded in errs against the line number, while
+// a body that can't be parsed as CSV at all is returned as an error.
+func (app *application) readCSVMovies(body io.Reader, errs rowErrors) ([]importRow, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, app.importReadError(err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"title", "year", "runtime", "genres"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("body is missing the %q column in the CSV header", name)
+		}
+	}
+
+	var rows []importRow
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, app.importReadError(err)
+		}
+
+		line, _ := reader.FieldPos(0)
+		field := func(name string) string {
+			i := columns[name]
+			if i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		row := importRow{line: line}
+		fieldErrors := make(map[string]string)
+
+		row.movie.Title = field("title")
+
+		if s := field("year"); s != "" {
+			row.movie.Year, err = strconv.Atoi(s)
+			if err != nil {
+				fieldErrors["year"] = "must be an integer value"
+			}
+		}
+
+		if s := field("runtime"); s != "" {
+			runtime, err := strconv.Atoi(s)
+			if err != nil {
+				fieldErrors["runtime"] = "must be an integer number of minutes"
+			}
+			row.movie.Runtime = data.Runtime(runtime)
+		}
+
+		if s := field("genres"); s != "" {
+			row.movie.Genres = strings.Split(s, "|")
+			for i := range row.movie.Genres {
+				row.movie.Genres[i] = strings.TrimSpace(row.movie.Genres[i])
+			}
+		}
+
+		for _, provider := range data.ExternalIDProviders {
+			if _, ok := columns[provider+"_id"]; !ok {
+				continue
+			}
+			if s := field(provider + "_id"); s != "" {
+				if row.movie.ExternalIDs == nil {
+					row.movie.ExternalIDs = make(data.ExternalIDs)
+				}
+				row.movie.ExternalIDs[provider] = s
+			}
+		}
+
+		if len(fieldErrors) > 0 {
+			errs[line] = fieldErrors
+			continue
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// The readNDJSONMovies() method reads movies from a JSON Lines body, where every
+// non-blank line holds one movie in the same format accepted by "POST /v1/movies",
+// plus an optional "external_ids" object such as {"imdb": "tt0111161"}.
+// Lines which can't be decoded are recorded in errs against the line number.
+func (app *application) readNDJSONMovies(body io.Reader, errs rowErrors) ([]importRow, error) {
+	scanner := bufio.NewScanner(body)
+	// Allow lines of up to 1MB, the same limit that readJSON() places on a single
+	// movie.
+	scanner.Buffer(make([]byte, 64*1024), 1_048_576)
+
+	var rows []importRow
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var input struct {
+			Title       string           `json:"title"`
+			Year        int              `json:"year"`
+			Runtime     data.Runtime     `json:"runtime"`
+			Genres      []string         `json:"genres"`
+			ExternalIDs data.ExternalIDs `json:"external_ids"`
+		}
+
+		dec := json.NewDecoder(strings.NewReader(text))
+		dec.DisallowUnknownFields()
+		err := dec.Decode(&input)
+		if err == nil && dec.More() {
+			err = errors.New("line must only contain a single JSON value")
+		}
+		if err != nil {
+			errs[line] = map[string]string{"row": err.Error()}
+			continue
+		}
+
+		rows = append(rows, importRow{
+			line: line,
+			movie: data.Movie{
+				Title:       input.Title,
+				Year:        input.Year,
+				Runtime:     input.Runtime,
+				Genres:      input.Genres,
+				ExternalIDs: input.ExternalIDs,
+			},
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, app.importReadError(err)
+	}
+
+	return rows, nil
+}
+
+// The importReadError() method turns an error from reading an import body into a
+// plain-english message for the client.
+func (app *application) importReadError(err error) error {
+	var maxBytesError *http.MaxBytesError
+	var parseError *csv.ParseError
+
+	switch {
+	case errors.As(err, &maxBytesError):
+		return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+	case errors.As(err, &parseError):
+		return fmt.Errorf("body contains badly-formed CSV (at line %d)", parseError.Line)
+	case errors.Is(err, bufio.ErrTooLong):
+		return errors.New("body contains a line longer than 1048576 bytes")
+	case errors.Is(err, io.EOF):
+		return errors.New("body must not be empty")
+	default:
+		return err
+	}
+}
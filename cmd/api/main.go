@@ -15,9 +15,11 @@ import (
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/startdusk/greenlight/internal/cache"
 	"github.com/startdusk/greenlight/internal/data"
 	"github.com/startdusk/greenlight/internal/jsonlog"
 	"github.com/startdusk/greenlight/internal/mailer"
+	"github.com/startdusk/greenlight/internal/storage"
 	"github.com/startdusk/greenlight/internal/vcs"
 
 	_ "github.com/lib/pq"
@@ -61,17 +63,46 @@ type config struct {
 	jwt struct {
 		secret string // Add a new field to store the JWT signing secret.
 	}
+
+	importer struct {
+		maxBytes int64 // Maximum size of a bulk movie import request body.
+	}
+
+	trash struct {
+		retention time.Duration // How long deleted movies are kept before being purged.
+	}
+
+	storage struct {
+		dir string // Directory where uploaded files are kept.
+	}
+
+	posters struct {
+		maxBytes int64 // Maximum size of an uploaded poster image.
+	}
+
+	similar struct {
+		cacheTTL  time.Duration // How long similar movie results are cached for.
+		cacheSize int           // Maximum number of pages of similar movies to cache.
+	}
 }
 
 // Define an application struct to hold the dependencies for our HTTP handlers, helpers,
 // and middleware. At the moment this only contains a copy of the config struct and a
 // logger, but it will grow to include a lot more as our build progresses.
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config  config
+	logger  *jsonlog.Logger
+	models  data.Models
+	mailer  mailer.Mailer
+	storage storage.Storage
+	wg      sync.WaitGroup
+
+	similarCache *cache.Cache[similarCacheKey, similarPage]
+	// Movie views counted since they were last written to the database.
+	views viewCounter
+	// The shutdown channel is closed once the server has stopped serving requests, to
+	// tell long-running background goroutines to stop.
+	shutdown chan struct{}
 }
 
 func main() {
@@ -101,6 +132,12 @@ func main() {
 		return nil
 	})
 	flag.StringVar(&cfg.jwt.secret, "jwt-secret", "", "JWT secret")
+	flag.Int64Var(&cfg.importer.maxBytes, "import-max-bytes", 50<<20, "Maximum size of a bulk movie import in bytes")
+	flag.StringVar(&cfg.storage.dir, "storage-dir", "./uploads", "Directory for uploaded files")
+	flag.Int64Var(&cfg.posters.maxBytes, "poster-max-bytes", 10<<20, "Maximum size of a movie poster upload in bytes")
+	flag.DurationVar(&cfg.similar.cacheTTL, "similar-cache-ttl", 10*time.Minute, "How long similar movie results are cached")
+	flag.IntVar(&cfg.similar.cacheSize, "similar-cache-size", 10000, "Maximum number of cached pages of similar movies")
+	flag.DurationVar(&cfg.trash.retention, "trash-retention", 30*24*time.Hour, "How long deleted movies are kept in the trash")
 	// Create a new version boolean flag with the default value of false.
 	displayVersion := flag.Bool("version", false, "Display version and exit")
 	flag.Parse()
@@ -160,13 +197,33 @@ func main() {
 		return time.Now().Unix()
 	}))
 
+	store, err := storage.NewFilesystem(cfg.storage.dir)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:  cfg,
+		logger:  logger,
+		models:  data.NewModels(db),
+		mailer:  mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		storage: store,
+
+		similarCache: cache.New[similarCacheKey, similarPage](cfg.similar.cacheTTL, cfg.similar.cacheSize),
+
+		shutdown: make(chan struct{}),
 	}
 
+	// Start the purger which permanently removes movies that have been in the trash
+	// for longer than the retention period.
+	app.background(app.purgeTrash)
+
+	// Start the job which keeps the recommendations model up to date.
+	app.background(app.rebuildRecommendations)
+
+	// Start the job which writes the counted movie views to the database.
+	app.background(app.flushViews)
+
 	err = app.serve()
 	if err != nil {
 		logger.Fatal(err)
@@ -3,10 +3,14 @@ package main
 import (
 	"errors"
 	"fmt"
+	"mime"
 	"net/http"
-	"strconv"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/startdusk/greenlight/internal/data"
+	"github.com/startdusk/greenlight/internal/jsonpatch"
 	"github.com/startdusk/greenlight/internal/validator"
 )
 
@@ -39,7 +43,9 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Insert(&movie)
+	user := app.contextGetUser(r)
+
+	err = app.models.Movies.Insert(&movie, user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -47,6 +53,7 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	headers.Set("ETag", movieETag(&movie))
 
 	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
 	if err != nil {
@@ -64,6 +71,14 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	v := validator.New()
+	selection := app.readFieldSelection(r.URL.Query(), v, movieFields, movieExpansions)
+	languages := app.readLanguages(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	movie, err := app.models.Movies.Get(id)
 	if err != nil {
 		switch {
@@ -75,7 +90,48 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	// Count the view for the trending statistics. This only bumps an in-memory counter,
+	// which is written to the database in the background.
+	app.views.add(movie.ID)
+
+	err = app.translateMovies([]*data.Movie{movie}, languages)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Add("Vary", "Accept-Language")
+	if movie.Language != "" {
+		w.Header().Set("Content-Language", movie.Language)
+	}
+
+	// The related data embedded by an expansion, like a translation, can change without
+	// the movie version changing, and a projection with fields= is a different
+	// representation from the full movie, so those responses get an ETag from a hash of
+	// the body instead.
+	if len(selection.fields) > 0 || len(selection.expand) > 0 || movie.Language != "" {
+		embedded, err := app.expandMovies([]*data.Movie{movie}, selection)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeConditionalJSON(w, r, envelope{"movie": selection.apply(movie, embedded[movie.ID])}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	etag := movieETag(movie)
+	if !app.checkPreconditions(w, r, etag) {
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": selection.apply(movie, nil)}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -99,39 +155,59 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// If the request contains a X-Expected-Version header, verify that the movie
-	// version in the database matches the expected version specified in the header.
-	version := r.Header.Get("X-Expected-Version")
-	if version != "" {
-		if strconv.FormatInt(int64(movie.Version), 32) != version {
-			app.editConflictResponse(w, r)
+	// If the request contains an If-Match header, only go ahead with the update when
+	// it matches the movie's current ETag.
+	if !app.checkPreconditions(w, r, movieETag(movie)) {
+		return
+	}
+
+	// Plain JSON bodies only change the fields they contain, while merge patches and
+	// JSON patches can also clear fields and edit individual genres.
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case mergePatchMediaType, jsonPatchMediaType:
+		err = app.patchMovie(w, r, movie, mediaType)
+		if err != nil {
+			switch {
+			case errors.Is(err, jsonpatch.ErrTestFailed):
+				app.errorResponse(w, r, http.StatusConflict, err.Error())
+			case errors.Is(err, jsonpatch.ErrPathNotFound):
+				app.errorResponse(w, r, http.StatusUnprocessableEntity, err.Error())
+			default:
+				app.badRequestResponse(w, r, err)
+			}
 			return
 		}
-	}
 
-	var input struct {
-		Title   *string       `json:"title"`
-		Year    *int          `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
-	}
-	err = app.readJSON(w, r, &input)
-	if err != nil {
-		app.badRequestResponse(w, r, err)
-		return
-	}
+	case "", "application/json":
+		var input struct {
+			Title   *string       `json:"title"`
+			Year    *int          `json:"year"`
+			Runtime *data.Runtime `json:"runtime"`
+			Genres  []string      `json:"genres"`
+		}
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
 
-	if input.Title != nil {
-		movie.Title = *input.Title
-	}
-	if input.Year != nil {
-		movie.Year = *input.Year
-	}
-	if input.Runtime != nil {
-		movie.Runtime = *input.Runtime
-	}
-	if input.Genres != nil {
-		movie.Genres = input.Genres
+		if input.Title != nil {
+			movie.Title = *input.Title
+		}
+		if input.Year != nil {
+			movie.Year = *input.Year
+		}
+		if input.Runtime != nil {
+			movie.Runtime = *input.Runtime
+		}
+		if input.Genres != nil {
+			movie.Genres = input.Genres
+		}
+
+	default:
+		app.unsupportedMediaTypeResponse(w, r, "application/json", mergePatchMediaType, jsonPatchMediaType)
+		return
 	}
 
 	v := validator.New()
@@ -140,7 +216,9 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Update(movie)
+	user := app.contextGetUser(r)
+
+	err = app.models.Movies.Update(movie, user.ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -151,7 +229,12 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	app.forgetSimilarMovies(movie.ID)
+
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -175,7 +258,9 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
+	app.forgetSimilarMovies(id)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully moved to trash"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -183,32 +268,242 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 
 func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title  string
-		Genres []string
+		Filter    data.MovieFilter
+		Facets    []string
+		Selection fieldSelection
+		Languages []string
 		data.Filters
 	}
 
 	v := validator.New()
 	qs := r.URL.Query()
-	input.Title = app.readString(qs, "title", "")
-	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.Filter = app.readMovieFilter(qs, v)
+	input.Selection = app.readFieldSelection(qs, v, movieFields, movieExpansions)
+	input.Languages = app.readLanguages(r, v)
+	input.Facets = app.readCSV(qs, "facets", []string{})
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+	// Sorting by relevance lists the best matches for the title search first, and
+	// sorting by -popularity the most viewed movies over the last 7 days.
+	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "rating", "relevance", "popularity", "-id", "-title", "-year", "-runtime", "-rating", "-relevance", "-popularity"}
+	for _, facet := range input.Facets {
+		v.Check(validator.PermittedValue(facet, data.MovieFacets...), "facets", "must only contain genres, decade or runtime_bucket")
+	}
+	v.Check(validator.Unique(input.Facets), "facets", "must not contain duplicate values")
+	data.ValidateMovieFilter(v, input.Filter)
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata, err := app.models.Movies.GetAll(input.Filter, input.Facets, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	err = app.translateMovies(movies, input.Languages)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	embedded, err := app.expandMovies(movies, input.Selection)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var items []any
+	for _, movie := range movies {
+		items = append(items, input.Selection.apply(movie, embedded[movie.ID]))
+	}
+
+	w.Header().Add("Vary", "Accept-Language")
+
+	err = app.writeConditionalJSON(w, r, envelope{"movies": items, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// The batchGetMoviesHandler fetches up to 100 movies by ID in one request. The movies
+// are returned in the order their IDs were given, and IDs which don't match a movie are
+// listed in "missing". The fields and expand parameters work as they do for the list
+// endpoint.
+func (app *application) batchGetMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		IDs []int64 `json:"ids"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	selection := app.readFieldSelection(r.URL.Query(), v, movieFields, movieExpansions)
+	v.Check(len(input.IDs) > 0, "ids", "must contain at least 1 id")
+	v.Check(len(input.IDs) <= 100, "ids", "must not contain more than 100 ids")
+	v.Check(validator.Unique(input.IDs), "ids", "must not contain duplicate values")
+	for _, id := range input.IDs {
+		v.Check(id > 0, "ids", "must only contain positive integers")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, err := app.models.Movies.GetMany(input.IDs)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	embedded, err := app.expandMovies(movies, selection)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	found := make(map[int64]bool, len(movies))
+	items := make([]any, len(movies))
+	for i, movie := range movies {
+		found[movie.ID] = true
+		items[i] = selection.apply(movie, embedded[movie.ID])
+	}
+
+	missing := []int64{}
+	for _, id := range input.IDs {
+		if !found[id] {
+			missing = append(missing, id)
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movies": items, "missing": missing}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The suggestMoviesHandler returns title completions for the text in the q query string
+// parameter, for search-as-you-type clients.
+func (app *application) suggestMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Query string
+		Limit int
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+	input.Query = strings.TrimSpace(app.readString(qs, "q", ""))
+	input.Limit = app.readInt(qs, "limit", 10, v)
+	v.Check(input.Query != "", "q", "must be provided")
+	v.Check(len(input.Query) <= 100, "q", "must not be more than 100 bytes long")
+	v.Check(input.Limit > 0, "limit", "must be greater than zero")
+	v.Check(input.Limit <= 20, "limit", "must be a maximum of 20")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	suggestions, err := app.models.Movies.Suggest(input.Query, input.Limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"suggestions": suggestions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readMovieFilter() helper reads the movie filters shared by the list and export
+// endpoints from the query string. Any values which can't be converted are recorded in
+// v, but the filter still needs to be checked with data.ValidateMovieFilter().
+func (app *application) readMovieFilter(qs url.Values, v *validator.Validator) data.MovieFilter {
+	return data.MovieFilter{
+		Title:         app.readString(qs, "title", ""),
+		Genres:        app.readCSV(qs, "genres", []string{}),
+		GenresAny:     app.readCSV(qs, "genres_any", []string{}),
+		GenresNot:     app.readCSV(qs, "genres_not", []string{}),
+		YearMin:       app.readInt(qs, "year_min", 0, v),
+		YearMax:       app.readInt(qs, "year_max", 0, v),
+		RuntimeMin:    app.readInt(qs, "runtime_min", 0, v),
+		RuntimeMax:    app.readInt(qs, "runtime_max", 0, v),
+		CreatedAfter:  app.readTime(qs, "created_after", time.Time{}, v),
+		CreatedBefore: app.readTime(qs, "created_before", time.Time{}, v),
+		PersonID:      int64(app.readInt(qs, "person", 0, v)),
+		Role:          app.readString(qs, "role", ""),
+
+		ReleasedIn:       strings.ToUpper(app.readString(qs, "released_in", "")),
+		ReleasedOnly:     app.readBool(qs, "released_only", false, v),
+		CertificationMax: app.readString(qs, "certification_max", ""),
+
+		Tags: app.readTags(qs, "tags"),
+	}
+}
+
+// The JSON fields of a movie which can be picked with the fields query string
+// parameter, and the related data which can be embedded with the expand parameter.
+var (
+	movieFields     = []string{"id", "title", "year", "runtime", "genres", "version", "average_rating", "rating_count", "original_title", "overview", "tagline", "language"}
+	movieExpansions = []string{"credits", "ratings", "external_ids"}
+)
+
+// The expandMovies() method fetches the related data requested in the selection for a
+// set of movies, keyed by movie ID and ready to pass to fieldSelection.apply(). Each
+// expansion costs one query however many movies there are.
+func (app *application) expandMovies(movies []*data.Movie, selection fieldSelection) (map[int64]envelope, error) {
+	embedded := make(map[int64]envelope, len(movies))
+	if len(selection.expand) == 0 || len(movies) == 0 {
+		return embedded, nil
+	}
+
+	ids := make([]int64, len(movies))
+	for i, movie := range movies {
+		ids[i] = movie.ID
+		embedded[movie.ID] = envelope{}
+	}
+
+	if selection.expands("credits") {
+		credits, err := app.models.Credits.GetAllForMovies(ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			// Send an empty list rather than null for movies without any credits.
+			embedded[id]["credits"] = append([]*data.Credit{}, credits[id]...)
+		}
+	}
+
+	if selection.expands("ratings") {
+		summaries, err := app.models.Reviews.RatingSummaries(ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			embedded[id]["ratings"] = summaries[id]
+		}
+	}
+
+	if selection.expands("external_ids") {
+		externalIDs, err := app.models.ExternalIDs.GetAllForMovies(ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			// Send an empty object rather than null for movies without any.
+			if externalIDs[id] == nil {
+				externalIDs[id] = data.ExternalIDs{}
+			}
+			embedded[id]["external_ids"] = externalIDs[id]
+		}
+	}
+
+	return embedded, nil
+}
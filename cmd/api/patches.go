@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/startdusk/greenlight/internal/data"
+	"github.com/startdusk/greenlight/internal/jsonpatch"
+)
+
+// The media types accepted for patch documents, in addition to plain JSON.
+const (
+	mergePatchMediaType = "application/merge-patch+json"
+	jsonPatchMediaType  = "application/json-patch+json"
+)
+
+// A moviePatchDocument holds the editable fields of a movie, and is the JSON document
+// that merge patches and JSON patches are applied to.
+type moviePatchDocument struct {
+	Title   string       `json:"title"`
+	Year    int          `json:"year"`
+	Runtime data.Runtime `json:"runtime"`
+	Genres  []string     `json:"genres"`
+}
+
+// The patchMovie() method applies the patch document in the request body to a movie.
+// The patch is applied to the whole of the movie's editable fields at once, so a
+// failed operation leaves the movie unchanged.
+func (app *application) patchMovie(w http.ResponseWriter, r *http.Request, movie *data.Movie, mediaType string) error {
+	// Limit the size of the request body to 1MB, the same as readJSON().
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+		}
+		return err
+	}
+
+	if len(bytes.TrimSpace(patch)) == 0 {
+		return errors.New("body must not be empty")
+	}
+
+	doc, err := json.Marshal(moviePatchDocument{
+		Title:   movie.Title,
+		Year:    movie.Year,
+		Runtime: movie.Runtime,
+		Genres:  movie.Genres,
+	})
+	if err != nil {
+		return err
+	}
+
+	switch mediaType {
+	case mergePatchMediaType:
+		doc, err = jsonpatch.MergePatch(doc, patch)
+	case jsonPatchMediaType:
+		doc, err = jsonpatch.Apply(doc, patch)
+	}
+	if err != nil {
+		return err
+	}
+
+	var patched moviePatchDocument
+
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&patched)
+	if err != nil {
+		return fmt.Errorf("patched movie is invalid: %v", err)
+	}
+
+	movie.Title = patched.Title
+	movie.Year = patched.Year
+	movie.Runtime = patched.Runtime
+	movie.Genres = patched.Genres
+
+	return nil
+}
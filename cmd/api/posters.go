@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	// Register the GIF decoder, the JPEG and PNG ones come with the encoders below.
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+
+	"github.com/startdusk/greenlight/internal/data"
+	"github.com/startdusk/greenlight/internal/storage"
+	"github.com/startdusk/greenlight/internal/validator"
+)
+
+// The image types accepted for posters.
+var posterContentTypes = []string{"image/jpeg", "image/png", "image/gif"}
+
+// The resized variants generated for every poster, mapped to their width in pixels.
+// The height is scaled to keep the aspect ratio.
+var posterSizes = map[string]int{
+	"small":  200,
+	"medium": 500,
+}
+
+// The limits on the dimensions of an uploaded poster, in pixels. The whole image is
+// decoded into memory to make the resized variants, at up to 4 bytes a pixel, so the
+// total number of pixels is capped too. That allows a 2:3 poster of 2800x4200.
+const (
+	posterMinDimension = 100
+	posterMaxWidth     = 4000
+	posterMaxHeight    = 6000
+	posterMaxPixels    = 12_000_000
+)
+
+// The posterKey() function returns the storage key for a size variant of a poster
+// stored under storageKey.
+func posterKey(storageKey, size string) string {
+	return storageKey + "/" + size
+}
+
+// The newPosterStorageKey() function returns a storage key for a new upload of a
+// movie's poster. Every upload gets a key of its own, so that uploads can't overwrite
+// each other's files.
+func newPosterStorageKey(movieID int64) (string, error) {
+	randomBytes := make([]byte, 8)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("posters/%d/%x", movieID, randomBytes), nil
+}
+
+// The deletePosterFiles() method deletes the original and the resized variants of a
+// poster from file storage.
+func (app *application) deletePosterFiles(storageKey string) error {
+	err := app.storage.Delete(posterKey(storageKey, "original"))
+	if err != nil {
+		return err
+	}
+
+	for size := range posterSizes {
+		err = app.storage.Delete(posterKey(storageKey, size))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The uploadPosterHandler stores a new poster for a movie, replacing any existing one.
+// The image can be sent as the raw request body or as the "poster" field of a
+// multipart form. The type of the image is worked out from its contents, whatever
+// Content-Type the client gave it.
+func (app *application) uploadPosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	body, err := app.readPosterBody(w, r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	contentType := http.DetectContentType(body)
+	if !validator.PermittedValue(contentType, posterContentTypes...) {
+		app.unsupportedMediaTypeResponse(w, r, posterContentTypes...)
+		return
+	}
+
+	v := validator.New()
+
+	// Check the dimensions from the image header before decoding the whole image, so
+	// that a small file claiming to be a huge image can't exhaust the memory.
+	config, _, err := image.DecodeConfig(bytes.NewReader(body))
+	if err != nil {
+		v.AddError("poster", "must be a valid image")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	v.Check(config.Width >= posterMinDimension && config.Height >= posterMinDimension, "poster", fmt.Sprintf("must be at least %d pixels wide and high", posterMinDimension))
+	v.Check(config.Width <= posterMaxWidth && config.Height <= posterMaxHeight, "poster", fmt.Sprintf("must not be more than %d pixels wide or %d pixels high", posterMaxWidth, posterMaxHeight))
+	v.Check(config.Width*config.Height <= posterMaxPixels, "poster", fmt.Sprintf("must not have more than %d pixels in total", posterMaxPixels))
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(body))
+	if err != nil {
+		v.AddError("poster", "must be a valid image")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// The files are stored under a key of their own and only replace the current poster
+	// once the database points at them, so a concurrent upload for the same movie can't
+	// mix its files in with these.
+	storageKey, err := newPosterStorageKey(movie.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.storePosterFiles(storageKey, img, body, contentType)
+	if err != nil {
+		app.discardPosterFiles(storageKey)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	poster := &data.Poster{
+		MovieID:     movie.ID,
+		ContentType: contentType,
+		Width:       config.Width,
+		Height:      config.Height,
+		Size:        int64(len(body)),
+		StorageKey:  storageKey,
+	}
+
+	previousKey, err := app.models.Posters.Upsert(poster)
+	if err != nil {
+		app.discardPosterFiles(storageKey)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if previousKey != "" {
+		app.discardPosterFiles(previousKey)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"poster": poster}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The storePosterFiles() method stores an uploaded poster and its resized variants
+// under storageKey.
+func (app *application) storePosterFiles(storageKey string, img image.Image, body []byte, contentType string) error {
+	for size, width := range posterSizes {
+		var buf bytes.Buffer
+		err := encodePosterVariant(&buf, resizeImage(img, width), contentType)
+		if err != nil {
+			return err
+		}
+
+		err = app.storage.Put(posterKey(storageKey, size), &buf)
+		if err != nil {
+			return err
+		}
+	}
+
+	return app.storage.Put(posterKey(storageKey, "original"), bytes.NewReader(body))
+}
+
+// The discardPosterFiles() method deletes poster files which are no longer needed. A
+// failure only leaves unused files behind, so it is logged rather than reported to the
+// client.
+func (app *application) discardPosterFiles(storageKey string) {
+	err := app.deletePosterFiles(storageKey)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"storage_key": storageKey})
+	}
+}
+
+// The showPosterHandler serves a movie's poster. The size query string parameter picks
+// one of the resized variants instead of the original image.
+func (app *application) showPosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	size := app.readString(r.URL.Query(), "size", "original")
+	if _, ok := posterSizes[size]; !ok {
+		v.Check(size == "original", "size", "must be one of original, small or medium")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Posters of movies in the trash aren't served, like the rest of their data.
+	_, err = app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	poster, err := app.models.Posters.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	obj, err := app.storage.Get(posterKey(poster.StorageKey, size))
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer obj.Close()
+
+	contentType := poster.ContentType
+	if size != "original" {
+		contentType = posterVariantContentType(poster.ContentType)
+	}
+
+	// The poster can be replaced at any time, so clients may cache it but should check
+	// back with the ETag after an hour. http.ServeContent() takes care of answering
+	// conditional and range requests.
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Header().Set("ETag", fmt.Sprintf(`"%d-%s"`, poster.Version, size))
+
+	http.ServeContent(w, r, "", poster.UploadedAt, obj)
+}
+
+// The readPosterBody() method reads an uploaded image, either from the raw request body
+// or from the "poster" field of a multipart form, limited to the configured size.
+func (app *application) readPosterBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, app.config.posters.maxBytes)
+
+	var src io.Reader = r.Body
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			return nil, err
+		}
+
+		for {
+			part, err := mr.NextPart()
+			if errors.Is(err, io.EOF) {
+				return nil, errors.New(`body must contain a "poster" field`)
+			}
+			if err != nil {
+				return nil, app.posterReadError(err)
+			}
+			if part.FormName() == "poster" {
+				src = part
+				break
+			}
+		}
+	}
+
+	body, err := io.ReadAll(src)
+	if err != nil {
+		return nil, app.posterReadError(err)
+	}
+
+	if len(body) == 0 {
+		return nil, errors.New("body must not be empty")
+	}
+
+	return body, nil
+}
+
+// The posterReadError() method turns an error from reading an upload into a
+// plain-english message for the client.
+func (app *application) posterReadError(err error) error {
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+	}
+	return err
+}
+
+// The posterVariantContentType() function returns the type that the resized variants
+// of a poster are encoded as. JPEG posters stay as JPEG, and everything else becomes
+// PNG so that transparency is kept.
+func posterVariantContentType(contentType string) string {
+	if contentType == "image/jpeg" {
+		return "image/jpeg"
+	}
+	return "image/png"
+}
+
+func encodePosterVariant(w io.Writer, img image.Image, contentType string) error {
+	if posterVariantContentType(contentType) == "image/jpeg" {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+	}
+	return png.Encode(w, img)
+}
+
+// The resizeImage() function scales an image down to the given width, keeping its
+// aspect ratio. Each pixel of the result is the average of a grid of samples taken
+// from the area of the original which it covers. Images which are already narrow
+// enough are only copied.
+func resizeImage(src image.Image, width int) image.Image {
+	bounds := src.Bounds()
+	if bounds.Dx() < width {
+		width = bounds.Dx()
+	}
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	// Take up to 4x4 samples for each destination pixel, which is plenty for a
+	// thumbnail and keeps the cost down for very large originals.
+	scaleX := float64(bounds.Dx()) / float64(width)
+	scaleY := float64(bounds.Dy()) / float64(height)
+	samplesX := int(math.Min(math.Max(math.Round(scaleX), 1), 4))
+	samplesY := int(math.Min(math.Max(math.Round(scaleY), 1), 4))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var r, g, b, a uint64
+			for sy := 0; sy < samplesY; sy++ {
+				for sx := 0; sx < samplesX; sx++ {
+					px := bounds.Min.X + int((float64(x)+(float64(sx)+0.5)/float64(samplesX))*scaleX)
+					py := bounds.Min.Y + int((float64(y)+(float64(sy)+0.5)/float64(samplesY))*scaleY)
+					c := color.NRGBA64Model.Convert(src.At(px, py)).(color.NRGBA64)
+					r += uint64(c.R)
+					g += uint64(c.G)
+					b += uint64(c.B)
+					a += uint64(c.A)
+				}
+			}
+			n := uint64(samplesX * samplesY)
+			dst.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(b / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+
+	return dst
+}
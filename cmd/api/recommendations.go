@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/startdusk/greenlight/internal/data"
+	"github.com/startdusk/greenlight/internal/validator"
+)
+
+// How often the recommendations model is rebuilt from the latest ratings, watchlists
+// and watch history.
+const recommendationsRebuildInterval = time.Hour
+
+// The listRecommendationsHandler returns the user's "For you" list. The source in the
+// response says whether the movies were picked from the user's own history or, for
+// users without enough of it yet, are simply the most popular ones.
+func (app *application) listRecommendationsHandler(w http.ResponseWriter, r *http.Request) {
+	var filters data.Filters
+
+	v := validator.New()
+	qs := r.URL.Query()
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	// Recommendations are always ordered by score.
+	filters.Sort = "-score"
+	filters.SortSafelist = []string{"-score"}
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	recommendations, metadata, source, err := app.models.Recommendations.GetForUser(user.ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recommendations": recommendations, "source": source, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The rebuildRecommendations() method precomputes the model behind the recommendations,
+// so that requests only have to look it up. It runs once at startup and then every
+// recommendationsRebuildInterval, until the server shuts down. A rebuild which is still
+// running then is abandoned, so it doesn't hold up the shutdown.
+func (app *application) rebuildRecommendations() {
+	ticker := time.NewTicker(recommendationsRebuildInterval)
+	defer ticker.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-app.shutdown:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		start := time.Now()
+		stored, err := app.models.Recommendations.Rebuild(ctx)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case errors.Is(err, data.ErrRebuildInProgress):
+			app.logger.PrintInfo("skipped recommendations rebuild", map[string]string{
+				"reason": err.Error(),
+			})
+		case err != nil:
+			app.logger.Error(err)
+		default:
+			app.logger.PrintInfo("rebuilt recommendations", map[string]string{
+				"similarities": fmt.Sprint(stored),
+				"duration":     time.Since(start).String(),
+			})
+		}
+
+		select {
+		case <-ticker.C:
+		case <-app.shutdown:
+			return
+		}
+	}
+}
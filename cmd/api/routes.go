@@ -24,9 +24,113 @@ func (app *application) routes() http.Handler {
 	{
 		router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
 		router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
-		router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:write", app.showMovieHandler))
 		router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:read", app.updateMovieHandler))
 		router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+		router.HandlerFunc(http.MethodPost, "/v1/movies/:id/restore", app.requirePermission("movies:write", app.restoreMovieHandler))
+		router.HandlerFunc(http.MethodGet, "/v1/movies/:id/similar", app.requirePermission("movies:read", app.listSimilarMoviesHandler))
+		router.HandlerFunc(http.MethodGet, "/v1/movies/:id/poster", app.requirePermission("movies:read", app.showPosterHandler))
+		router.HandlerFunc(http.MethodPut, "/v1/movies/:id/poster", app.requirePermission("movies:write", app.uploadPosterHandler))
+
+		// These routes also serve the fixed paths under /v1/movies, such as
+		// /v1/movies/export. See the fixedRoutes() helper for why.
+		router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.fixedRoutes(map[string]http.HandlerFunc{
+			"export":   app.requirePermission("movies:read", app.exportMoviesHandler),
+			"trash":    app.requirePermission("movies:write", app.listTrashHandler),
+			"suggest":  app.requirePermission("movies:read", app.suggestMoviesHandler),
+			"lookup":   app.requirePermission("movies:read", app.lookupMovieHandler),
+			"trending": app.requirePermission("movies:read", app.trendingMoviesHandler),
+		}, app.requirePermission("movies:write", app.showMovieHandler)))
+		router.HandlerFunc(http.MethodPost, "/v1/movies/:id", app.fixedRoutes(map[string]http.HandlerFunc{
+			"import":    app.requirePermission("movies:write", app.importMoviesHandler),
+			"batch-get": app.requirePermission("movies:read", app.batchGetMoviesHandler),
+			"batch":     app.requirePermission("movies:write", app.batchMoviesHandler),
+		}, nil))
+	}
+
+	//======================================================================================================
+	// reviews handler
+	{
+		router.HandlerFunc(http.MethodGet, "/v1/movies/:id/reviews", app.requirePermission("movies:read", app.listReviewsHandler))
+		router.HandlerFunc(http.MethodPost, "/v1/movies/:id/reviews", app.requireActivatedUser(app.createReviewHandler))
+		router.HandlerFunc(http.MethodPatch, "/v1/movies/:id/reviews", app.requireActivatedUser(app.updateReviewHandler))
+		router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/reviews", app.requireActivatedUser(app.deleteReviewHandler))
+	}
+
+	//======================================================================================================
+	// revisions handler
+	{
+		router.HandlerFunc(http.MethodGet, "/v1/movies/:id/revisions", app.requirePermission("movies:read", app.listMovieRevisionsHandler))
+		router.HandlerFunc(http.MethodGet, "/v1/movies/:id/revisions/:version", app.requirePermission("movies:read", app.showMovieRevisionHandler))
+		router.HandlerFunc(http.MethodPost, "/v1/movies/:id/revisions/:version/restore", app.requirePermission("movies:write", app.restoreMovieRevisionHandler))
+	}
+
+	//======================================================================================================
+	// people handler
+	{
+		router.HandlerFunc(http.MethodGet, "/v1/people", app.requirePermission("movies:read", app.listPeopleHandler))
+		router.HandlerFunc(http.MethodPost, "/v1/people", app.requirePermission("movies:write", app.createPersonHandler))
+		router.HandlerFunc(http.MethodGet, "/v1/people/:id", app.requirePermission("movies:read", app.showPersonHandler))
+		router.HandlerFunc(http.MethodPatch, "/v1/people/:id", app.requirePermission("movies:write", app.updatePersonHandler))
+		router.HandlerFunc(http.MethodDelete, "/v1/people/:id", app.requirePermission("movies:write", app.deletePersonHandler))
+	}
+
+	//======================================================================================================
+	// credits handler
+	{
+		router.HandlerFunc(http.MethodGet, "/v1/movies/:id/credits", app.requirePermission("movies:read", app.listMovieCreditsHandler))
+		router.HandlerFunc(http.MethodPut, "/v1/movies/:id/credits", app.requirePermission("movies:write", app.replaceMovieCreditsHandler))
+	}
+
+	//======================================================================================================
+	// releases handler
+	{
+		router.HandlerFunc(http.MethodGet, "/v1/movies/:id/releases", app.requirePermission("movies:read", app.listMovieReleasesHandler))
+		router.HandlerFunc(http.MethodPut, "/v1/movies/:id/releases", app.requirePermission("movies:write", app.replaceMovieReleasesHandler))
+	}
+
+	//======================================================================================================
+	// external ids handler
+	{
+		router.HandlerFunc(http.MethodGet, "/v1/movies/:id/external_ids", app.requirePermission("movies:read", app.listMovieExternalIDsHandler))
+		router.HandlerFunc(http.MethodPut, "/v1/movies/:id/external_ids", app.requirePermission("movies:write", app.replaceMovieExternalIDsHandler))
+	}
+
+	//======================================================================================================
+	// translations handler
+	{
+		router.HandlerFunc(http.MethodGet, "/v1/movies/:id/translations", app.requirePermission("movies:read", app.listMovieTranslationsHandler))
+		router.HandlerFunc(http.MethodGet, "/v1/movies/:id/translations/:lang", app.requirePermission("movies:read", app.showMovieTranslationHandler))
+		router.HandlerFunc(http.MethodPut, "/v1/movies/:id/translations/:lang", app.requirePermission("movies:write", app.putMovieTranslationHandler))
+		router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/translations/:lang", app.requirePermission("movies:write", app.deleteMovieTranslationHandler))
+	}
+
+	//======================================================================================================
+	// collections handler
+	{
+		// Public collections can be read without logging in, so the read routes check
+		// visibility in the handlers rather than with requirePermission().
+		router.HandlerFunc(http.MethodGet, "/v1/collections", app.listCollectionsHandler)
+		router.HandlerFunc(http.MethodPost, "/v1/collections", app.requireActivatedUser(app.createCollectionHandler))
+		router.HandlerFunc(http.MethodGet, "/v1/collections/:id", app.showCollectionHandler)
+		router.HandlerFunc(http.MethodPatch, "/v1/collections/:id", app.requireActivatedUser(app.updateCollectionHandler))
+		router.HandlerFunc(http.MethodDelete, "/v1/collections/:id", app.requireActivatedUser(app.deleteCollectionHandler))
+		router.HandlerFunc(http.MethodGet, "/v1/collections/:id/movies", app.listCollectionMoviesHandler)
+		router.HandlerFunc(http.MethodPost, "/v1/collections/:id/movies", app.requireActivatedUser(app.addCollectionMovieHandler))
+		router.HandlerFunc(http.MethodPatch, "/v1/collections/:id/movies/:movie_id", app.requireActivatedUser(app.moveCollectionMovieHandler))
+		router.HandlerFunc(http.MethodDelete, "/v1/collections/:id/movies/:movie_id", app.requireActivatedUser(app.removeCollectionMovieHandler))
+		router.HandlerFunc(http.MethodGet, "/v1/movies/:id/collections", app.listMovieCollectionsHandler)
+	}
+
+	//======================================================================================================
+	// tags handler
+	{
+		router.HandlerFunc(http.MethodGet, "/v1/tags", app.requirePermission("movies:read", app.listTagsHandler))
+		router.HandlerFunc(http.MethodGet, "/v1/movies/:id/tags", app.requirePermission("movies:read", app.listMovieTagsHandler))
+		router.HandlerFunc(http.MethodPost, "/v1/movies/:id/tags", app.requireActivatedUser(app.addMovieTagHandler))
+		router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/tags/:tag", app.requireActivatedUser(app.removeMovieTagHandler))
+		router.HandlerFunc(http.MethodGet, "/v1/tags/banned", app.requirePermission("tags:moderate", app.listBannedTagsHandler))
+		router.HandlerFunc(http.MethodPut, "/v1/tags/banned/:tag", app.requirePermission("tags:moderate", app.banTagHandler))
+		router.HandlerFunc(http.MethodDelete, "/v1/tags/banned/:tag", app.requirePermission("tags:moderate", app.unbanTagHandler))
 	}
 
 	//======================================================================================================
@@ -37,6 +141,19 @@ func (app *application) routes() http.Handler {
 		router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
 	}
 
+	//======================================================================================================
+	// watchlist handler
+	{
+		router.HandlerFunc(http.MethodGet, "/v1/users/me/watchlist", app.requireActivatedUser(app.listWatchlistHandler))
+		router.HandlerFunc(http.MethodPost, "/v1/users/me/watchlist", app.requirePermission("watchlist:write", app.addWatchlistItemHandler))
+		router.HandlerFunc(http.MethodPatch, "/v1/users/me/watchlist/:id", app.requirePermission("watchlist:write", app.moveWatchlistItemHandler))
+		router.HandlerFunc(http.MethodDelete, "/v1/users/me/watchlist/:id", app.requirePermission("watchlist:write", app.removeWatchlistItemHandler))
+		router.HandlerFunc(http.MethodGet, "/v1/users/me/history", app.requireActivatedUser(app.listWatchHistoryHandler))
+		router.HandlerFunc(http.MethodPost, "/v1/users/me/history", app.requirePermission("watchlist:write", app.markWatchedHandler))
+		router.HandlerFunc(http.MethodDelete, "/v1/users/me/history/:id", app.requirePermission("watchlist:write", app.deleteWatchHistoryEntryHandler))
+		router.HandlerFunc(http.MethodGet, "/v1/users/me/recommendations", app.requireActivatedUser(app.listRecommendationsHandler))
+	}
+
 	//======================================================================================================
 	// tokens handler
 	{
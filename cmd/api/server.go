@@ -49,8 +49,16 @@ func (app *application) serve() error {
 		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 		defer cancel()
 
+		// Stop the server first, letting in-flight requests finish, so that nothing the
+		// background goroutines are responsible for, like the counted movie views, can
+		// arrive after they have stopped.
+		err := srv.Shutdown(ctx)
+
+		// Then tell the background goroutines which run until shutdown to stop, and
+		// wait for all of them to finish.
+		close(app.shutdown)
 		app.wg.Wait()
-		shutdownError <- srv.Shutdown(ctx)
+		shutdownError <- err
 	}()
 
 	// Likewise log a "starting server" message.
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/startdusk/greenlight/internal/data"
+	"github.com/startdusk/greenlight/internal/validator"
+)
+
+// Similar movies are cached by the movie and the page of results asked for.
+type similarCacheKey struct {
+	movieID  int64
+	page     int
+	pageSize int
+}
+
+type similarPage struct {
+	movies   []*data.SimilarMovie
+	metadata data.Metadata
+}
+
+// The listSimilarMoviesHandler returns the movies most like a given one, best match
+// first, for "more like this" rails. Scoring is expensive, so pages of results are
+// cached for a while. Changes to a movie evict the pages it could be on, but pages can
+// still be a little behind other changes to the catalogue, like new reviews.
+func (app *application) listSimilarMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var filters data.Filters
+
+	v := validator.New()
+	qs := r.URL.Query()
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	// Results are always ordered by how similar they are.
+	filters.Sort = "-score"
+	filters.SortSafelist = []string{"-score"}
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	key := similarCacheKey{movieID: id, page: filters.Page, pageSize: filters.PageSize}
+
+	page, err := app.similarCache.GetOrLoad(key, func() (similarPage, error) {
+		movies, metadata, err := app.models.Movies.GetSimilar(id, filters)
+		return similarPage{movies: movies, metadata: metadata}, err
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movies": page.movies, "metadata": page.metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The forgetSimilarMovies() method evicts the cached similar movies which changes to the
+// given movies could affect: their own pages, and every page they appear on.
+func (app *application) forgetSimilarMovies(movieIDs ...int64) {
+	if len(movieIDs) == 0 {
+		return
+	}
+
+	changed := make(map[int64]bool, len(movieIDs))
+	for _, id := range movieIDs {
+		changed[id] = true
+	}
+
+	app.similarCache.DeleteFunc(func(key similarCacheKey, page similarPage) bool {
+		if changed[key.movieID] {
+			return true
+		}
+		for _, similar := range page.movies {
+			if changed[similar.Movie.ID] {
+				return true
+			}
+		}
+		return false
+	})
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/startdusk/greenlight/internal/data"
+	"github.com/startdusk/greenlight/internal/validator"
+)
+
+// The readTags() helper reads a comma-separated list of tags from the query string,
+// normalized in the same way as the tags users give movies. Empty entries are dropped.
+func (app *application) readTags(qs url.Values, key string) []string {
+	var tags []string
+	for _, tag := range app.readCSV(qs, key, []string{}) {
+		if tag = data.NormalizeTag(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// The readTagParam() helper retrieves the normalized "tag" URL parameter from the
+// current request context.
+func (app *application) readTagParam(r *http.Request) (string, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	tag := data.NormalizeTag(params.ByName("tag"))
+	if tag == "" {
+		return "", errors.New("invalid tag parameter")
+	}
+	return tag, nil
+}
+
+// The listMovieTagsHandler lists the tags users have given a movie, with how many
+// users gave each one.
+func (app *application) listMovieTagsHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Movies.Get(movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	tags, err := app.models.Tags.GetAllForMovie(movieID, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"tags": tags}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The addMovieTagHandler tags a movie on behalf of the user. Giving a movie the same
+// tag twice has no further effect.
+func (app *application) addMovieTagHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Tag string `json:"tag"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	tag := data.NormalizeTag(input.Tag)
+
+	v := validator.New()
+	if data.ValidateTag(v, tag); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.models.Movies.Get(movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	created, err := app.models.Tags.Add(movieID, user.ID, tag)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrBannedTag):
+			v.AddError("tag", "has been banned by a moderator")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+
+	err = app.writeJSON(w, status, envelope{"tag": tag}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The removeMovieTagHandler takes away a tag the user gave a movie. Tags given by other
+// users are left alone.
+func (app *application) removeMovieTagHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	tag, err := app.readTagParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.Tags.Remove(movieID, user.ID, tag)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "tag successfully removed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listTagsHandler returns the tag cloud: the most used tags across the catalogue
+// with how many times each has been given. The q parameter narrows it down to tags
+// starting with some text, for autocompleting tags as users type them.
+func (app *application) listTagsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Prefix string
+		Limit  int
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+	input.Prefix = data.NormalizeTag(app.readString(qs, "q", ""))
+	input.Limit = app.readInt(qs, "limit", 50, v)
+	v.Check(len(input.Prefix) <= 50, "q", "must not be more than 50 bytes long")
+	v.Check(input.Limit > 0, "limit", "must be greater than zero")
+	v.Check(input.Limit <= 200, "limit", "must be a maximum of 200")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	tags, err := app.models.Tags.GetPopular(input.Prefix, input.Limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"tags": tags}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listBannedTagsHandler(w http.ResponseWriter, r *http.Request) {
+	tags, err := app.models.Tags.GetAllBanned()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"banned_tags": tags}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The banTagHandler bans a tag across the catalogue. The tag is taken off every movie
+// straight away and can't be given again until it's unbanned.
+func (app *application) banTagHandler(w http.ResponseWriter, r *http.Request) {
+	tag, err := app.readTagParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Reason string `json:"reason"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input.Reason) <= 500, "reason", "must not be more than 500 bytes long")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	removed, err := app.models.Tags.Ban(tag, user.ID, input.Reason)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"banned_tag": tag, "removed": removed}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) unbanTagHandler(w http.ResponseWriter, r *http.Request) {
+	tag, err := app.readTagParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Tags.Unban(tag)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "tag successfully unbanned"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/startdusk/greenlight/internal/data"
+	"github.com/startdusk/greenlight/internal/validator"
+)
+
+// The most language tags taken from a request, counting the fallbacks to base
+// languages, so that a long Accept-Language header can't blow up the lookup.
+const maxLanguages = 10
+
+// The canonicalLanguageTag() function puts a BCP 47 language tag into the case we store
+// translations under: a lower case language, title case script and upper case region,
+// so that "PT-br" and "pt-BR" find the same translation.
+func canonicalLanguageTag(tag string) string {
+	subtags := strings.Split(strings.TrimSpace(tag), "-")
+	for i, subtag := range subtags {
+		switch {
+		case i == 0:
+			subtags[i] = strings.ToLower(subtag)
+		case len(subtag) == 2:
+			subtags[i] = strings.ToUpper(subtag)
+		case len(subtag) == 4:
+			subtags[i] = strings.ToUpper(subtag[:1]) + strings.ToLower(subtag[1:])
+		default:
+			subtags[i] = strings.ToLower(subtag)
+		}
+	}
+	return strings.Join(subtags, "-")
+}
+
+// The readLanguages() method returns the languages the client wants movies in, most
+// preferred first. The lang query string parameter takes precedence over the
+// Accept-Language header. Each tag with subtags is followed by its base language, so a
+// client asking for "pt-BR" still gets a "pt" translation if there's no better one. An
+// empty result means the movies should be left untranslated.
+func (app *application) readLanguages(r *http.Request, v *validator.Validator) []string {
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		tag := canonicalLanguageTag(lang)
+		v.Check(validator.Matches(tag, data.LanguageTagRX), "lang", "must be a valid BCP 47 language tag")
+		return languageFallbacks([]string{tag})
+	}
+
+	return languageFallbacks(parseAcceptLanguage(r.Header.Get("Accept-Language")))
+}
+
+// The parseAcceptLanguage() function returns the language tags in an Accept-Language
+// header ordered by their quality values. Tags we can't store translations under, the
+// "*" wildcard and tags with a quality of zero are skipped rather than rejected, as
+// browsers send all sorts in this header.
+func parseAcceptLanguage(header string) []string {
+	type weightedTag struct {
+		tag     string
+		quality float64
+	}
+
+	var tags []weightedTag
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = canonicalLanguageTag(tag)
+		if !validator.Matches(tag, data.LanguageTagRX) {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.EqualFold(name, "q") {
+				q, err := strconv.ParseFloat(value, 64)
+				if err != nil || q < 0 || q > 1 {
+					q = 0
+				}
+				quality = q
+			}
+		}
+		if quality == 0 {
+			continue
+		}
+
+		tags = append(tags, weightedTag{tag: tag, quality: quality})
+	}
+
+	// Tags with the same quality keep the order the client sent them in.
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].quality > tags[j].quality
+	})
+
+	languages := make([]string, len(tags))
+	for i, t := range tags {
+		languages[i] = t.tag
+	}
+	return languages
+}
+
+// The languageFallbacks() function follows each language tag with its base language,
+// dropping duplicates and anything past maxLanguages.
+func languageFallbacks(tags []string) []string {
+	var languages []string
+	seen := make(map[string]bool)
+
+	for _, tag := range tags {
+		base, _, _ := strings.Cut(tag, "-")
+		for _, language := range []string{tag, base} {
+			if seen[language] || len(languages) == maxLanguages {
+				continue
+			}
+			seen[language] = true
+			languages = append(languages, language)
+		}
+	}
+
+	return languages
+}
+
+// The translateMovies() method replaces the title of each movie with the translation
+// which best matches the given languages, keeping the original title in OriginalTitle.
+// Movies without a matching translation are left as they are.
+func (app *application) translateMovies(movies []*data.Movie, languages []string) error {
+	if len(movies) == 0 || len(languages) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(movies))
+	for i, movie := range movies {
+		ids[i] = movie.ID
+	}
+
+	translations, err := app.models.Translations.GetBestForMovies(ids, languages)
+	if err != nil {
+		return err
+	}
+
+	for _, movie := range movies {
+		translation, ok := translations[movie.ID]
+		if !ok {
+			continue
+		}
+
+		movie.OriginalTitle = movie.Title
+		movie.Title = translation.Title
+		movie.Overview = translation.Overview
+		movie.Tagline = translation.Tagline
+		movie.Language = translation.Language
+	}
+
+	return nil
+}
+
+func (app *application) listMovieTranslationsHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Movies.Get(movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	translations, err := app.models.Translations.GetAllForMovie(movieID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"translations": translations}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showMovieTranslationHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	language, err := app.readLanguageParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	translation, err := app.models.Translations.Get(movieID, language)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"translation": translation}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The putMovieTranslationHandler creates or replaces the translation of a movie into a
+// language. Replacing a translation needs the version it was read at, while creating
+// one needs the version left out, so two clients can't overwrite each other's work.
+func (app *application) putMovieTranslationHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	language, err := app.readLanguageParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Movies.Get(movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Title    string `json:"title"`
+		Overview string `json:"overview"`
+		Tagline  string `json:"tagline"`
+		Version  int    `json:"version"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	translation := &data.Translation{
+		Language: language,
+		Title:    input.Title,
+		Overview: input.Overview,
+		Tagline:  input.Tagline,
+		Version:  input.Version,
+	}
+
+	v := validator.New()
+	v.Check(input.Version >= 0, "version", "must not be negative")
+	if data.ValidateTranslation(v, translation); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Translations.Upsert(movieID, translation)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	status := http.StatusOK
+	if input.Version == 0 {
+		status = http.StatusCreated
+	}
+
+	err = app.writeJSON(w, status, envelope{"translation": translation}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteMovieTranslationHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	language, err := app.readLanguageParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Translations.Delete(movieID, language)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "translation successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
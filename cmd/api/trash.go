@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/startdusk/greenlight/internal/data"
+	"github.com/startdusk/greenlight/internal/validator"
+)
+
+// How often the purger checks the trash for movies past the retention period.
+const trashPurgeInterval = time.Hour
+
+// The listTrashHandler lists the movies which have been deleted but not yet purged.
+func (app *application) listTrashHandler(w http.ResponseWriter, r *http.Request) {
+	var filters data.Filters
+
+	v := validator.New()
+	qs := r.URL.Query()
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-deleted_at")
+	filters.SortSafelist = []string{"id", "title", "deleted_at", "-id", "-title", "-deleted_at"}
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, metadata, err := app.models.Movies.GetAllDeleted(filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The restoreMovieHandler takes a movie back out of the trash.
+func (app *application) restoreMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Movies.Restore(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.forgetSimilarMovies(id)
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The purgeTrash() method permanently removes movies which have been in the trash for
+// longer than the retention period. It runs once at startup and then every
+// trashPurgeInterval, until the server starts shutting down.
+func (app *application) purgeTrash() {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		purged, posterKeys, err := app.models.Movies.PurgeDeleted(app.config.trash.retention)
+		if err != nil {
+			app.logger.Error(err)
+		} else if purged > 0 {
+			app.logger.PrintInfo("purged movies from trash", map[string]string{
+				"count":     fmt.Sprint(purged),
+				"retention": app.config.trash.retention.String(),
+			})
+		}
+
+		// The posters of the purged movies are gone from the database, so delete their
+		// images too.
+		for _, posterKey := range posterKeys {
+			app.discardPosterFiles(posterKey)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-app.shutdown:
+			return
+		}
+	}
+}
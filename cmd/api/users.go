@@ -53,8 +53,8 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// Add the "movies:read" permission for the new user.
-	err = app.models.Permissions.AddForUser(user.ID, "movies:read")
+	// Add the "movies:read" and "watchlist:write" permissions for the new user.
+	err = app.models.Permissions.AddForUser(user.ID, "movies:read", "watchlist:write")
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
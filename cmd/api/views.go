@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/startdusk/greenlight/internal/data"
+	"github.com/startdusk/greenlight/internal/validator"
+)
+
+// How often the movie views counted in memory are written to the rollup tables, and
+// how long the hourly rollups are kept for. Only the 24h trending window reads the
+// hourly rollups; the daily ones are kept for good.
+const (
+	viewsFlushInterval   = 10 * time.Second
+	hourlyViewsRetention = 48 * time.Hour
+)
+
+// A viewCounter counts movie views between flushes to the database, so that recording
+// a view never makes a request wait on the database. The zero value is ready to use and
+// it is safe for concurrent use.
+type viewCounter struct {
+	mu     sync.Mutex
+	counts map[int64]int64
+}
+
+func (c *viewCounter) add(movieID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.counts == nil {
+		c.counts = make(map[int64]int64)
+	}
+	c.counts[movieID]++
+}
+
+// The take() method returns the views counted so far and starts counting from zero.
+func (c *viewCounter) take() map[int64]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	counts := c.counts
+	c.counts = nil
+	return counts
+}
+
+// The trendingMoviesHandler returns the most viewed movies over the last 24 hours or
+// the last 7 days, most viewed first. Views are written in batches, so the counts can be
+// up to viewsFlushInterval behind.
+func (app *application) trendingMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Window string
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+	input.Window = app.readString(qs, "window", "24h")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	// Trending movies are always ordered by views.
+	input.Filters.Sort = "-views"
+	input.Filters.SortSafelist = []string{"-views"}
+	v.Check(validator.PermittedValue(input.Window, data.TrendingWindows...), "window", "must be one of "+strings.Join(data.TrendingWindows, ", "))
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, metadata, err := app.models.Views.GetTrending(input.Window, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "window": input.Window, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The flushViews() method writes the views counted in memory to the rollup tables every
+// viewsFlushInterval, and prunes the hourly rollups which are no longer needed. Once the
+// server has stopped serving requests it writes what has been counted one last time and
+// stops.
+// A batch which fails to be written is dropped rather than retried, as the views are
+// only used for statistics.
+func (app *application) flushViews() {
+	ticker := time.NewTicker(viewsFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		var stopping bool
+		select {
+		case <-ticker.C:
+		case <-app.shutdown:
+			stopping = true
+		}
+
+		err := app.models.Views.Record(app.views.take(), time.Now())
+		if err != nil {
+			app.logger.Error(err)
+		}
+
+		if stopping {
+			return
+		}
+
+		_, err = app.models.Views.PruneHourly(time.Now().Add(-hourlyViewsRetention))
+		if err != nil {
+			app.logger.Error(err)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// Package cache provides a small in-memory cache whose entries expire after a fixed
+// time, for results which are expensive to calculate and fine to serve slightly stale.
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type entry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time
+}
+
+// A call is a load of a value which is in progress. Other callers asking for the same
+// key wait for it rather than starting their own.
+type call[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+// A Cache maps keys to values for a limited time. When it is full, the least recently
+// used entry makes way for a new one. It is safe for concurrent use.
+type Cache[K comparable, V any] struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[K]*list.Element
+	order      *list.List // Entries from the most to the least recently used
+	calls      map[K]*call[V]
+	// Bumped whenever entries are deleted, so that loads which started before then
+	// don't cache what could be out of date.
+	generation uint64
+}
+
+// New returns a Cache which keeps entries for ttl and holds at most maxEntries of them.
+func New[K comparable, V any](ttl time.Duration, maxEntries int) *Cache[K, V] {
+	return &Cache[K, V]{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[K]*list.Element),
+		order:      list.New(),
+		calls:      make(map[K]*call[V]),
+	}
+}
+
+// GetOrLoad returns the value cached under key. If there isn't one, or it has expired,
+// it calls load and caches the result, unless load returns an error. Concurrent
+// callers asking for the same key share a single call to load.
+func (c *Cache[K, V]) GetOrLoad(key K, load func() (V, error)) (V, error) {
+	c.mu.Lock()
+
+	if value, ok := c.get(key); ok {
+		c.mu.Unlock()
+		return value, nil
+	}
+
+	if cl, ok := c.calls[key]; ok {
+		c.mu.Unlock()
+		<-cl.done
+		return cl.value, cl.err
+	}
+
+	cl := &call[V]{done: make(chan struct{})}
+	c.calls[key] = cl
+	generation := c.generation
+	c.mu.Unlock()
+
+	// Clean up even if load panics, so that the callers waiting on it aren't left
+	// hanging.
+	defer func() {
+		c.mu.Lock()
+		delete(c.calls, key)
+		if cl.err == nil && generation == c.generation {
+			c.set(key, cl.value)
+		}
+		c.mu.Unlock()
+		close(cl.done)
+	}()
+
+	cl.value, cl.err = load()
+	return cl.value, cl.err
+}
+
+// DeleteFunc deletes every entry for which fn returns true. Loads which are in progress
+// when it is called don't cache their results.
+func (c *Cache[K, V]) DeleteFunc(fn func(key K, value V) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+
+	for key, el := range c.entries {
+		if fn(key, el.Value.(*entry[K, V]).value) {
+			c.order.Remove(el)
+			delete(c.entries, key)
+		}
+	}
+}
+
+// The get() method returns the value cached under key, marking it as recently used,
+// and false if there isn't one or it has expired. The mutex must be held.
+func (c *Cache[K, V]) get(key K) (V, bool) {
+	el, ok := c.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+
+	e := el.Value.(*entry[K, V])
+	if time.Now().After(e.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		var zero V
+		return zero, false
+	}
+
+	c.order.MoveToFront(el)
+	return e.value, true
+}
+
+// The set() method caches value under key, evicting the least recently used entry if
+// the cache is full. The mutex must be held.
+func (c *Cache[K, V]) set(key K, value V) {
+	e := &entry[K, V]{key: key, value: value, expires: time.Now().Add(c.ttl)}
+
+	if el, ok := c.entries[key]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return
+	}
+
+	if len(c.entries) >= c.maxEntries {
+		oldest := c.order.Back()
+		if oldest == nil {
+			return
+		}
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*entry[K, V]).key)
+	}
+
+	c.entries[key] = c.order.PushFront(e)
+}
@@ -0,0 +1,141 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Define a custom ErrBatchAborted error, returned when an atomic batch is rolled back
+// because one of its operations failed.
+var (
+	ErrBatchAborted = errors.New("batch aborted")
+)
+
+// The operations which can be included in a movie batch.
+var MovieOperations = []string{"create", "update", "delete"}
+
+// A MovieOperation is a single change in a batch. Creates and updates use every field
+// of Movie that can be edited, while deletes only use the ID. Updates must carry the
+// version being replaced, and deletes can do so to get the same check.
+type MovieOperation struct {
+	Op    string
+	Movie *Movie
+	Err   error // The outcome of the operation, set by Batch()
+	Done  bool  // Whether the operation was carried out, set by Batch()
+}
+
+// Batch carries out a list of movie operations in a single transaction, recording
+// their outcomes on the operations themselves. Operations which already have Err set,
+// for example because they failed validation, are skipped.
+//
+// In atomic mode the first operation to fail with ErrRecordNotFound or ErrEditConflict
+// rolls back the whole batch, and ErrBatchAborted is returned. Otherwise each operation
+// runs in its own savepoint, so a failed one is undone on its own and the rest of the
+// batch carries on. Any other error is returned straight away, and nothing is saved.
+func (m MovieModel) Batch(ops []*MovieOperation, userID int64, atomic bool) error {
+	// Use a longer timeout than usual, as a batch can contain many operations.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	for _, op := range ops {
+		if op.Err != nil {
+			continue
+		}
+
+		if !atomic {
+			_, err = tx.ExecContext(ctx, "SAVEPOINT movie_operation")
+			if err != nil {
+				return err
+			}
+		}
+
+		switch op.Op {
+		case "create":
+			err = insertMovie(ctx, tx, op.Movie, userID)
+		case "update":
+			// Check the movie exists first, so that a missing movie is reported as
+			// such rather than as an edit conflict.
+			_, err = lockMovie(ctx, tx, op.Movie.ID)
+			if err == nil {
+				err = updateMovie(ctx, tx, op.Movie, userID)
+			}
+		case "delete":
+			err = deleteMovie(ctx, tx, op.Movie.ID, op.Movie.Version)
+		}
+
+		switch {
+		case err == nil:
+			op.Done = true
+		case errors.Is(err, ErrRecordNotFound), errors.Is(err, ErrEditConflict):
+			op.Err = err
+			if atomic {
+				for _, op := range ops {
+					op.Done = false
+				}
+				return ErrBatchAborted
+			}
+			_, err = tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT movie_operation")
+		default:
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		if !atomic {
+			_, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT movie_operation")
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// The lockMovie() function locks a movie which isn't in the trash until the end of the
+// transaction, and returns its current version.
+func lockMovie(ctx context.Context, tx *sql.Tx, id int64) (int, error) {
+	var version int
+	err := tx.QueryRowContext(ctx, `
+		SELECT version
+		FROM movies
+		WHERE id = $1 AND deleted_at IS NULL
+		FOR UPDATE
+	`, id).Scan(&version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return version, nil
+}
+
+// The deleteMovie() function moves a movie to the trash as part of a transaction, like
+// Delete(). If version isn't zero, the movie must still be at that version.
+func deleteMovie(ctx context.Context, tx *sql.Tx, id int64, version int) error {
+	current, err := lockMovie(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	if version != 0 && version != current {
+		return ErrEditConflict
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE movies SET deleted_at = NOW() WHERE id = $1`, id)
+	return err
+}
@@ -0,0 +1,523 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/startdusk/greenlight/internal/validator"
+)
+
+// Who can see a collection. These mirror the collections_visibility_check constraint
+// in the database.
+var CollectionVisibilities = []string{"public", "private"}
+
+// Define a custom ErrDuplicateCollectionItem error, returned when a movie is added to a
+// collection that already contains it.
+var (
+	ErrDuplicateCollectionItem = errors.New("duplicate collection item")
+)
+
+// A Collection is an ordered list of movies curated by a user, such as "Criterion
+// picks" or a franchise like "Marvel Cinematic Universe".
+type Collection struct {
+	ID          int64     `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	OwnerID     int64     `json:"owner_id"` // ID of the user who created the collection
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Visibility  string    `json:"visibility"`
+	MovieCount  int       `json:"movie_count"` // Number of movies in the collection, only populated on reads
+	Version     int       `json:"version"`
+}
+
+type CollectionItem struct {
+	Position int       `json:"position"` // 1-based position of the movie in the collection
+	AddedAt  time.Time `json:"added_at"`
+	Movie    *Movie    `json:"movie"`
+}
+
+func ValidateCollection(v *validator.Validator, collection *Collection) {
+	v.Check(collection.Name != "", "name", "must be provided")
+	v.Check(len(collection.Name) <= 200, "name", "must not be more than 200 bytes long")
+	v.Check(len(collection.Description) <= 5000, "description", "must not be more than 5000 bytes long")
+	v.Check(validator.PermittedValue(collection.Visibility, CollectionVisibilities...), "visibility", "must be one of "+strings.Join(CollectionVisibilities, ", "))
+}
+
+// The collectionVisible fragment matches the collections a user can see: public ones,
+// their own, and every collection if they are an administrator. It expects the user ID
+// and whether they are an administrator as $1 and $2.
+const collectionVisible = `(collections.visibility = 'public' OR collections.user_id = $1 OR $2)`
+
+// The collectionMovieCount fragment counts the movies in a collection, leaving out
+// those in the trash.
+const collectionMovieCount = `(
+	SELECT COUNT(*)
+	FROM collection_items
+	INNER JOIN movies ON movies.id = collection_items.movie_id
+	WHERE collection_items.collection_id = collections.id AND movies.deleted_at IS NULL
+)`
+
+type CollectionModel struct {
+	DB *sql.DB
+}
+
+func (m CollectionModel) Insert(collection *Collection) error {
+	const query = `
+		INSERT INTO collections (user_id, name, description, visibility)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version
+	`
+
+	args := []any{collection.OwnerID, collection.Name, collection.Description, collection.Visibility}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&collection.ID, &collection.CreatedAt, &collection.Version)
+}
+
+func (m CollectionModel) Get(id int64) (*Collection, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, created_at, user_id, name, description, visibility, %s, version
+		FROM collections
+		WHERE id = $1
+	`, collectionMovieCount)
+
+	var collection Collection
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&collection.ID,
+		&collection.CreatedAt,
+		&collection.OwnerID,
+		&collection.Name,
+		&collection.Description,
+		&collection.Visibility,
+		&collection.MovieCount,
+		&collection.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &collection, nil
+}
+
+// GetAll returns the collections userID can see, optionally only those owned by
+// ownerID and with the given visibility. The admin flag lets administrators see
+// everyone's private collections too.
+func (m CollectionModel) GetAll(userID int64, admin bool, ownerID int64, visibility string, filters Filters) ([]*Collection, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), id, created_at, user_id, name, description, visibility, %s, version
+		FROM collections
+		WHERE %s
+		AND (user_id = $3 OR $3 = 0)
+		AND (visibility = $4 OR $4 = '')
+		ORDER BY %s %s, id ASC
+		LIMIT $5 OFFSET $6
+	`, collectionMovieCount, collectionVisible, filters.sortColumn(), filters.sortDirection())
+
+	args := []any{userID, admin, ownerID, visibility, filters.limit(), filters.offset()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	var totalRecords int
+	var collections []*Collection
+
+	for rows.Next() {
+		var collection Collection
+		err := rows.Scan(
+			&totalRecords,
+			&collection.ID,
+			&collection.CreatedAt,
+			&collection.OwnerID,
+			&collection.Name,
+			&collection.Description,
+			&collection.Visibility,
+			&collection.MovieCount,
+			&collection.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		collections = append(collections, &collection)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return collections, metadata, nil
+}
+
+// GetAllForMovie returns the collections userID can see which contain a movie, ordered
+// by name.
+func (m CollectionModel) GetAllForMovie(movieID, userID int64, admin bool) ([]*Collection, error) {
+	query := fmt.Sprintf(`
+		SELECT collections.id, collections.created_at, collections.user_id, collections.name,
+			collections.description, collections.visibility, %s, collections.version
+		FROM collections
+		INNER JOIN collection_items ON collection_items.collection_id = collections.id
+		WHERE %s AND collection_items.movie_id = $3
+		ORDER BY collections.name, collections.id
+	`, collectionMovieCount, collectionVisible)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, admin, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var collections []*Collection
+
+	for rows.Next() {
+		var collection Collection
+		err := rows.Scan(
+			&collection.ID,
+			&collection.CreatedAt,
+			&collection.OwnerID,
+			&collection.Name,
+			&collection.Description,
+			&collection.Visibility,
+			&collection.MovieCount,
+			&collection.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		collections = append(collections, &collection)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return collections, nil
+}
+
+func (m CollectionModel) Update(collection *Collection) error {
+	const query = `
+		UPDATE collections
+		SET name = $1, description = $2, visibility = $3, version = version + 1
+		WHERE id = $4 AND version = $5
+		RETURNING version
+	`
+
+	args := []any{collection.Name, collection.Description, collection.Visibility, collection.ID, collection.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&collection.Version)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrEditConflict
+	default:
+		return err
+	}
+}
+
+func (m CollectionModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	const query = `
+		DELETE FROM collections
+		WHERE id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetItems returns a page of the movies in a collection. Movies in the trash are left
+// out.
+func (m CollectionModel) GetItems(collectionID int64, filters Filters) ([]*CollectionItem, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), collection_items.position, collection_items.added_at,
+			movies.id, movies.created_at, title, year, runtime, genres, movies.version,
+			COALESCE(ratings.average_rating, 0), COALESCE(ratings.rating_count, 0)
+		FROM collection_items
+		INNER JOIN movies ON movies.id = collection_items.movie_id
+		%s
+		WHERE collection_items.collection_id = $1 AND movies.deleted_at IS NULL
+		ORDER BY %s %s, movies.id ASC
+		LIMIT $2 OFFSET $3
+	`, movieRatingsJoin, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, collectionID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	var totalRecords int
+	var items []*CollectionItem
+
+	for rows.Next() {
+		var item CollectionItem
+		var movie Movie
+		err := rows.Scan(
+			&totalRecords,
+			&item.Position,
+			&item.AddedAt,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		item.Movie = &movie
+		items = append(items, &item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return items, metadata, nil
+}
+
+// The lockCollection() function locks a collection row for the rest of a transaction,
+// so concurrent changes to the order of the same collection are applied one after the
+// other, and returns the number of movies in it.
+func lockCollection(ctx context.Context, tx *sql.Tx, collectionID int64) (int, error) {
+	_, err := tx.ExecContext(ctx, `SELECT 1 FROM collections WHERE id = $1 FOR UPDATE`, collectionID)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM collection_items WHERE collection_id = $1`, collectionID).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// AddItem inserts a movie into a collection at the given position, shifting the movies
+// from there onwards down by one place. A position of zero, or one past the end of the
+// collection, appends the movie.
+func (m CollectionModel) AddItem(collectionID, movieID int64, position int) (*CollectionItem, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	count, err := lockCollection(ctx, tx, collectionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if position < 1 || position > count {
+		position = count + 1
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE collection_items SET position = position + 1
+		WHERE collection_id = $1 AND position >= $2
+	`, collectionID, position)
+	if err != nil {
+		return nil, err
+	}
+
+	item := CollectionItem{Movie: &Movie{ID: movieID}}
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO collection_items (collection_id, movie_id, position)
+		VALUES ($1, $2, $3)
+		RETURNING position, added_at
+	`, collectionID, movieID, position).Scan(&item.Position, &item.AddedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "collection_items_pkey"`:
+			return nil, ErrDuplicateCollectionItem
+		default:
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+// MoveItem changes the position of a movie in a collection, shifting the movies in
+// between up or down by one place. Positions outside the collection are clamped to the
+// first or last place.
+func (m CollectionModel) MoveItem(collectionID, movieID int64, position int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	count, err := lockCollection(ctx, tx, collectionID)
+	if err != nil {
+		return err
+	}
+
+	var current int
+	err = tx.QueryRowContext(ctx, `
+		SELECT position
+		FROM collection_items
+		WHERE collection_id = $1 AND movie_id = $2
+	`, collectionID, movieID).Scan(&current)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if position < 1 {
+		position = 1
+	}
+	if position > count {
+		position = count
+	}
+
+	switch {
+	case position < current:
+		_, err = tx.ExecContext(ctx, `
+			UPDATE collection_items SET position = position + 1
+			WHERE collection_id = $1 AND position >= $2 AND position < $3
+		`, collectionID, position, current)
+	case position > current:
+		_, err = tx.ExecContext(ctx, `
+			UPDATE collection_items SET position = position - 1
+			WHERE collection_id = $1 AND position > $2 AND position <= $3
+		`, collectionID, current, position)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE collection_items SET position = $3
+		WHERE collection_id = $1 AND movie_id = $2
+	`, collectionID, movieID, position)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RemoveItem removes a movie from a collection and closes the gap it leaves behind.
+func (m CollectionModel) RemoveItem(collectionID, movieID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	_, err = lockCollection(ctx, tx, collectionID)
+	if err != nil {
+		return err
+	}
+
+	var position int
+	err = tx.QueryRowContext(ctx, `
+		DELETE FROM collection_items
+		WHERE collection_id = $1 AND movie_id = $2
+		RETURNING position
+	`, collectionID, movieID).Scan(&position)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE collection_items SET position = position - 1
+		WHERE collection_id = $1 AND position > $2
+	`, collectionID, position)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
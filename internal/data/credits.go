@@ -0,0 +1,179 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/startdusk/greenlight/internal/validator"
+)
+
+// The roles a person can be credited with on a movie. These mirror the
+// movie_credits_role_check constraint in the database.
+var CreditRoles = []string{"director", "actor", "writer"}
+
+// Define a custom ErrUnknownPerson error, returned when a credit refers to a person
+// that doesn't exist.
+var (
+	ErrUnknownPerson = errors.New("unknown person")
+)
+
+type Credit struct {
+	PersonID     int64  `json:"person_id"`
+	Name         string `json:"name,omitempty"` // Name of the person, only populated on reads
+	Role         string `json:"role"`
+	Character    string `json:"character,omitempty"` // Character played, for actors
+	BillingOrder int    `json:"billing_order"`
+}
+
+func ValidateCredits(v *validator.Validator, credits []Credit) {
+	v.Check(credits != nil, "credits", "must be provided")
+	keys := make([]string, len(credits))
+	for i, credit := range credits {
+		key := fmt.Sprintf("credits[%d]", i)
+		v.Check(credit.PersonID > 0, key, "person_id must be provided")
+		v.Check(validator.PermittedValue(credit.Role, CreditRoles...), key, "role must be one of "+strings.Join(CreditRoles, ", "))
+		v.Check(len(credit.Character) <= 500, key, "character must not be more than 500 bytes long")
+		v.Check(credit.BillingOrder >= 0, key, "billing_order must not be negative")
+		keys[i] = fmt.Sprintf("%d:%s", credit.PersonID, credit.Role)
+	}
+	v.Check(validator.Unique(keys), "credits", "must not credit the same person with the same role twice")
+}
+
+type CreditModel struct {
+	DB *sql.DB
+}
+
+// GetAllForMovie returns the credits for a movie, grouped by role and then in billing
+// order.
+func (m CreditModel) GetAllForMovie(movieID int64) ([]*Credit, error) {
+	const query = `
+		SELECT movie_credits.person_id, people.name, movie_credits.role, movie_credits.character, movie_credits.billing_order
+		FROM movie_credits
+		INNER JOIN people ON people.id = movie_credits.person_id
+		WHERE movie_credits.movie_id = $1
+		ORDER BY movie_credits.role, movie_credits.billing_order, people.name
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var credits []*Credit
+
+	for rows.Next() {
+		var credit Credit
+		err := rows.Scan(
+			&credit.PersonID,
+			&credit.Name,
+			&credit.Role,
+			&credit.Character,
+			&credit.BillingOrder,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		credits = append(credits, &credit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
+
+// GetAllForMovies returns the credits for several movies at once, keyed by movie ID and
+// in the same order as GetAllForMovie(). Movies without any credits are left out.
+func (m CreditModel) GetAllForMovies(movieIDs []int64) (map[int64][]*Credit, error) {
+	const query = `
+		SELECT movie_credits.movie_id, movie_credits.person_id, people.name, movie_credits.role, movie_credits.character, movie_credits.billing_order
+		FROM movie_credits
+		INNER JOIN people ON people.id = movie_credits.person_id
+		WHERE movie_credits.movie_id = ANY($1)
+		ORDER BY movie_credits.movie_id, movie_credits.role, movie_credits.billing_order, people.name
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := make(map[int64][]*Credit)
+
+	for rows.Next() {
+		var movieID int64
+		var credit Credit
+		err := rows.Scan(
+			&movieID,
+			&credit.PersonID,
+			&credit.Name,
+			&credit.Role,
+			&credit.Character,
+			&credit.BillingOrder,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		credits[movieID] = append(credits[movieID], &credit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
+
+// ReplaceForMovie swaps the full set of credits for a movie in a single transaction,
+// so readers never see a half-updated cast list.
+func (m CreditModel) ReplaceForMovie(movieID int64, credits []Credit) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM movie_credits WHERE movie_id = $1`, movieID)
+	if err != nil {
+		return err
+	}
+
+	const query = `
+		INSERT INTO movie_credits (movie_id, person_id, role, character, billing_order)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+
+	for _, credit := range credits {
+		_, err = tx.ExecContext(ctx, query, movieID, credit.PersonID, credit.Role, credit.Character, credit.BillingOrder)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: insert or update on table "movie_credits" violates foreign key constraint "movie_credits_person_id_fkey"`:
+				return ErrUnknownPerson
+			default:
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
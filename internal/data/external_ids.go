@@ -0,0 +1,326 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/startdusk/greenlight/internal/validator"
+)
+
+// The catalogues we hold identifiers from. These mirror the
+// movie_external_ids_provider_check constraint in the database.
+var ExternalIDProviders = []string{"imdb", "tmdb", "eidr"}
+
+// The format of the identifiers issued by each provider: IMDb title IDs such as
+// "tt0111161", numeric TMDb movie IDs, and EIDR content IDs such as
+// "10.5240/7791-8534-2C23-9030-8610-5".
+var externalIDFormats = map[string]*regexp.Regexp{
+	"imdb": regexp.MustCompile(`^tt[0-9]{7,10}$`),
+	"tmdb": regexp.MustCompile(`^[1-9][0-9]{0,9}$`),
+	"eidr": regexp.MustCompile(`^10\.5240/([0-9A-F]{4}-){5}[0-9A-Z]$`),
+}
+
+// Define a custom ErrDuplicateExternalID error, returned when an identifier already
+// belongs to another movie, and ErrExternalIDConflict, returned when the identifiers of
+// an imported movie match more than one movie, or a movie in the trash.
+var (
+	ErrDuplicateExternalID = errors.New("duplicate external id")
+	ErrExternalIDConflict  = errors.New("external ids match more than one movie")
+)
+
+// ExternalIDs maps the name of a provider to the identifier it uses for a movie.
+type ExternalIDs map[string]string
+
+func ValidateExternalIDs(v *validator.Validator, ids ExternalIDs) {
+	v.Check(ids != nil, "external_ids", "must be provided")
+	for _, provider := range ids.providers() {
+		format, ok := externalIDFormats[provider]
+		if !ok {
+			v.AddError("external_ids", "provider must be one of "+strings.Join(ExternalIDProviders, ", "))
+			continue
+		}
+		v.Check(validator.Matches(ids[provider], format), "external_ids", fmt.Sprintf("%s must be a valid %s id", provider, provider))
+	}
+}
+
+// The providers() method returns the providers in a set of identifiers in alphabetical
+// order, so that they're always written and validated in the same order.
+func (ids ExternalIDs) providers() []string {
+	providers := make([]string, 0, len(ids))
+	for provider := range ids {
+		providers = append(providers, provider)
+	}
+	sort.Strings(providers)
+	return providers
+}
+
+type ExternalIDModel struct {
+	DB *sql.DB
+}
+
+// GetAllForMovie returns the external identifiers of a movie. A movie without any gets
+// an empty map.
+func (m ExternalIDModel) GetAllForMovie(movieID int64) (ExternalIDs, error) {
+	const query = `
+		SELECT provider, value
+		FROM movie_external_ids
+		WHERE movie_id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(ExternalIDs)
+
+	for rows.Next() {
+		var provider, value string
+		err := rows.Scan(&provider, &value)
+		if err != nil {
+			return nil, err
+		}
+
+		ids[provider] = value
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// GetAllForMovies returns the external identifiers of several movies at once, keyed by
+// movie ID. Movies without any are left out.
+func (m ExternalIDModel) GetAllForMovies(movieIDs []int64) (map[int64]ExternalIDs, error) {
+	const query = `
+		SELECT movie_id, provider, value
+		FROM movie_external_ids
+		WHERE movie_id = ANY($1)
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[int64]ExternalIDs)
+
+	for rows.Next() {
+		var movieID int64
+		var provider, value string
+		err := rows.Scan(&movieID, &provider, &value)
+		if err != nil {
+			return nil, err
+		}
+
+		if ids[movieID] == nil {
+			ids[movieID] = make(ExternalIDs)
+		}
+		ids[movieID][provider] = value
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// ReplaceForMovie swaps the full set of external identifiers for a movie in a single
+// transaction. It returns ErrDuplicateExternalID if one of them already belongs to
+// another movie.
+func (m ExternalIDModel) ReplaceForMovie(movieID int64, ids ExternalIDs) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM movie_external_ids WHERE movie_id = $1`, movieID)
+	if err != nil {
+		return err
+	}
+
+	err = insertExternalIDs(ctx, tx, movieID, ids)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The insertExternalIDs() function adds identifiers to a movie as part of a
+// transaction, replacing any the movie already has from the same providers.
+func insertExternalIDs(ctx context.Context, tx *sql.Tx, movieID int64, ids ExternalIDs) error {
+	const query = `
+		INSERT INTO movie_external_ids (movie_id, provider, value)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (movie_id, provider) DO UPDATE
+		SET value = EXCLUDED.value
+	`
+
+	for _, provider := range ids.providers() {
+		_, err := tx.ExecContext(ctx, query, movieID, provider, ids[provider])
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "movie_external_ids_provider_value_key"`:
+				return ErrDuplicateExternalID
+			default:
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetByExternalID fetches the movie a provider's identifier belongs to.
+func (m MovieModel) GetByExternalID(provider, value string) (*Movie, error) {
+	const query = `
+		SELECT movie_id
+		FROM movie_external_ids
+		WHERE provider = $1 AND value = $2
+	`
+
+	var id int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, provider, value).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return m.Get(id)
+}
+
+// The upsertMovieByExternalIDs() function imports a movie with external identifiers as
+// part of a transaction. If any of the identifiers already belong to a movie then that
+// movie is updated to match, otherwise a new movie is inserted, and either way the
+// identifiers are attached to it. This is what makes importing the same feed twice
+// safe. The created and changed results report which of these happened, with a movie
+// that already matched leaving both false.
+func upsertMovieByExternalIDs(ctx context.Context, tx *sql.Tx, movie *Movie, userID int64) (created, changed bool, err error) {
+	providers := movie.ExternalIDs.providers()
+	values := make([]string, len(providers))
+	for i, provider := range providers {
+		values[i] = movie.ExternalIDs[provider]
+	}
+
+	// Take a lock on each identifier, so that a concurrent import of the same movie
+	// waits for this one rather than racing it, even when the movie is new and there is
+	// no row to lock yet. The locks are held until the transaction ends, and are taken in
+	// a fixed order so that imports sharing several identifiers can't deadlock.
+	_, err = tx.ExecContext(ctx, `
+		SELECT pg_advisory_xact_lock(key)
+		FROM (
+			SELECT DISTINCT hashtext(provider || ':' || value) AS key
+			FROM unnest($1::text[], $2::text[]) AS ids (provider, value)
+			ORDER BY key
+		) keys
+	`, pq.Array(providers), pq.Array(values))
+	if err != nil {
+		return false, false, err
+	}
+
+	// Lock the matching movies too, as they can also be changed outside imports.
+	const query = `
+		SELECT movies.id, movies.title, movies.year, movies.runtime, movies.genres, movies.version,
+			movies.deleted_at IS NOT NULL
+		FROM movies
+		WHERE movies.id IN (
+			SELECT movie_id
+			FROM movie_external_ids
+			WHERE (provider, value) IN (SELECT * FROM unnest($1::text[], $2::text[]))
+		)
+		FOR UPDATE
+	`
+
+	rows, err := tx.QueryContext(ctx, query, pq.Array(providers), pq.Array(values))
+	if err != nil {
+		return false, false, err
+	}
+	defer rows.Close()
+
+	var matches []*Movie
+	var trashed bool
+
+	for rows.Next() {
+		var match Movie
+		err := rows.Scan(
+			&match.ID,
+			&match.Title,
+			&match.Year,
+			&match.Runtime,
+			pq.Array(&match.Genres),
+			&match.Version,
+			&trashed,
+		)
+		if err != nil {
+			return false, false, err
+		}
+
+		matches = append(matches, &match)
+	}
+
+	if err = rows.Err(); err != nil {
+		return false, false, err
+	}
+
+	switch {
+	case len(matches) > 1 || trashed:
+		return false, false, ErrExternalIDConflict
+
+	case len(matches) == 0:
+		err = insertMovie(ctx, tx, movie, userID)
+		if err != nil {
+			return false, false, err
+		}
+		created = true
+
+	default:
+		movie.ID = matches[0].ID
+		movie.Version = matches[0].Version
+		if len(diffMovies(matches[0], movie)) > 0 {
+			err = updateMovie(ctx, tx, movie, userID)
+			if err != nil {
+				return false, false, err
+			}
+			changed = true
+		}
+	}
+
+	err = insertExternalIDs(ctx, tx, movie.ID, movie.ExternalIDs)
+	if err != nil {
+		return false, false, err
+	}
+
+	return created, changed, nil
+}
@@ -0,0 +1,66 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The facets which can be counted alongside a movie search.
+var MovieFacets = []string{"genres", "decade", "runtime_bucket"}
+
+// A FacetCount holds the number of matching movies which have a facet value.
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// The movieFacetQueries map holds, for each facet, a SQL query counting the movies in
+// the filtered result set (the "filtered" common table expression in GetAll()) by
+// facet value. Every query returns the counts as a JSON array of FacetCount objects.
+var movieFacetQueries = map[string]string{
+	"genres": `
+		SELECT COALESCE(json_agg(json_build_object('value', genre, 'count', count) ORDER BY count DESC, genre), '[]')
+		FROM (
+			SELECT genre, COUNT(*) AS count
+			FROM filtered, unnest(filtered.genres) AS genre
+			GROUP BY genre
+		) facet`,
+	"decade": `
+		SELECT COALESCE(json_agg(json_build_object('value', decade || 's', 'count', count) ORDER BY decade), '[]')
+		FROM (
+			SELECT year / 10 * 10 AS decade, COUNT(*) AS count
+			FROM filtered
+			GROUP BY decade
+		) facet`,
+	"runtime_bucket": `
+		SELECT COALESCE(json_agg(json_build_object('value', bucket, 'count', count) ORDER BY bucket_order), '[]')
+		FROM (
+			SELECT
+				CASE
+					WHEN runtime < 90 THEN '0-89'
+					WHEN runtime < 120 THEN '90-119'
+					WHEN runtime < 150 THEN '120-149'
+					ELSE '150+'
+				END AS bucket,
+				MIN(runtime) AS bucket_order,
+				COUNT(*) AS count
+			FROM filtered
+			GROUP BY bucket
+		) facet`,
+}
+
+// The movieFacetsColumn() function returns a SQL expression which builds a JSON object
+// holding the counts for each of the given facets, keyed by facet name. The facet
+// queries don't depend on the current row, so PostgreSQL only runs them once per query.
+func movieFacetsColumn(facets []string) string {
+	if len(facets) == 0 {
+		return "NULL::json"
+	}
+
+	pairs := make([]string, len(facets))
+	for i, facet := range facets {
+		pairs[i] = fmt.Sprintf("'%s', (%s)", facet, movieFacetQueries[facet])
+	}
+
+	return fmt.Sprintf("json_build_object(%s)", strings.Join(pairs, ", "))
+}
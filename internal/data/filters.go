@@ -1,6 +1,10 @@
 package data
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"strings"
 
 	"github.com/startdusk/greenlight/internal/validator"
@@ -11,6 +15,46 @@ type Filters struct {
 	PageSize     int
 	Sort         string
 	SortSafelist []string
+	Cursor       string // Opaque keyset cursor, takes the place of Page when set
+}
+
+// The cursor struct holds the position of the last row on a page: the value of the
+// sort column and the row id, which is always used as the tie-breaker. The sort value
+// itself is recorded too, so a cursor can't be replayed against a different ordering.
+type cursor struct {
+	Sort  string `json:"s"`
+	Value any    `json:"v"`
+	ID    int64  `json:"id"`
+}
+
+func encodeCursor(c cursor) string {
+	js, err := json.Marshal(c)
+	if err != nil {
+		// The cursor only ever contains strings and numbers, so this can't happen
+		// unless there's a logic error in our codebase.
+		panic(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, err
+	}
+
+	var c cursor
+	dec := json.NewDecoder(bytes.NewReader(js))
+	// Decode numbers as json.Number so that large ids and ratings keep their exact
+	// textual representation when they are sent back to PostgreSQL.
+	dec.UseNumber()
+	err = dec.Decode(&c)
+	if err != nil {
+		return cursor{}, err
+	}
+
+	return c, nil
 }
 
 // Check that the client-provided Sort field matches one of the entries in our safelist
@@ -43,6 +87,37 @@ func (f Filters) offset() int {
 	return (f.Page - 1) * f.PageSize
 }
 
+// Return a SQL condition which restricts a query to the rows after the position held in
+// the cursor, along with the arguments for it. The placeholders are numbered from
+// argPosition. Rows are always ordered by id ASC within equal sort values, so that is
+// the direction used for the tie-breaker regardless of the sort direction. When no
+// cursor is set the condition matches every row.
+func (f Filters) cursorCondition(argPosition int) (string, []any, error) {
+	if f.Cursor == "" {
+		return "TRUE", nil, nil
+	}
+
+	c, err := decodeCursor(f.Cursor)
+	if err != nil {
+		return "", nil, err
+	}
+
+	operator := ">"
+	if f.sortDirection() == "DESC" {
+		operator = "<"
+	}
+
+	condition := fmt.Sprintf("(%[1]s %[2]s $%[3]d OR (%[1]s = $%[3]d AND id > $%[4]d))",
+		f.sortColumn(), operator, argPosition, argPosition+1)
+
+	return condition, []any{c.Value, c.ID}, nil
+}
+
+// Return the cursor pointing after a row with the given sort column value and id.
+func (f Filters) nextCursor(value any, id int64) string {
+	return encodeCursor(cursor{Sort: f.Sort, Value: value, ID: id})
+}
+
 func ValidateFilters(v *validator.Validator, f Filters) {
 	// Check that the page and page_size parameters contain sensible values.
 	v.Check(f.Page > 0, "page", "must be greater than zero")
@@ -51,4 +126,77 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
 	// Check that the sort parameter matches a value in the safelist.
 	v.Check(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+
+	// A cursor replaces the page number, so the two can't be combined. The cursor must
+	// also have been issued for the sort order that is being requested.
+	if f.Cursor != "" {
+		v.Check(f.Page == 1, "page", "must not be used together with cursor")
+
+		c, err := decodeCursor(f.Cursor)
+		v.Check(err == nil, "cursor", "invalid cursor value")
+		v.Check(err != nil || c.Sort == f.Sort, "cursor", "must be used with the sort value it was issued for")
+
+		// The sort value is sent to PostgreSQL as it is, so a tampered cursor must not
+		// get any further than this.
+		if err == nil && validator.PermittedValue(f.Sort, f.SortSafelist...) {
+			v.Check(validCursorValue(c.Value, f.sortColumn()), "cursor", "invalid cursor value")
+		}
+	}
+}
+
+// The sort columns holding text and whole numbers. Every other sort column holds
+// floating point numbers.
+var (
+	textSortColumns    = []string{"title"}
+	integerSortColumns = []string{"id", "year", "runtime", "popularity"}
+)
+
+// The validCursorValue() function reports whether a value decoded from a cursor is a
+// single value of the right type for the sort column.
+func validCursorValue(value any, column string) bool {
+	text := validator.PermittedValue(column, textSortColumns...)
+
+	switch value := value.(type) {
+	case string:
+		return text
+	case json.Number:
+		if text {
+			return false
+		}
+		if validator.PermittedValue(column, integerSortColumns...) {
+			_, err := value.Int64()
+			return err == nil
+		}
+		_, err := value.Float64()
+		return err == nil
+	default:
+		return false
+	}
+}
+
+// The sqlConditions type collects the conditions for a WHERE clause along with their
+// arguments, so that optional filters can be added one at a time without keeping track
+// of the placeholder numbers by hand.
+type sqlConditions struct {
+	conditions []string
+	args       []any
+}
+
+// Add an argument and return the placeholder which refers to it.
+func (c *sqlConditions) arg(value any) string {
+	c.args = append(c.args, value)
+	return fmt.Sprintf("$%d", len(c.args))
+}
+
+// Add a condition. Any values in it must be referred to through arg() placeholders.
+func (c *sqlConditions) add(condition string) {
+	c.conditions = append(c.conditions, condition)
+}
+
+// Return the conditions joined with AND, or TRUE if there aren't any.
+func (c *sqlConditions) where() string {
+	if len(c.conditions) == 0 {
+		return "TRUE"
+	}
+	return strings.Join(c.conditions, " AND ")
 }
@@ -15,19 +15,47 @@ var (
 // Create a Models struct which wraps the MovieModel. We'll add other models to this,
 // like a UserModel and PermissionModel, as our build progresses.
 type Models struct {
-	Movies      MovieModel
-	Users       UserModel
-	Tokens      TokenModel
-	Permissions PermissionModel
+	Movies          MovieModel
+	Users           UserModel
+	Tokens          TokenModel
+	Permissions     PermissionModel
+	Reviews         ReviewModel
+	People          PersonModel
+	Credits         CreditModel
+	Watchlist       WatchlistModel
+	WatchHistory    WatchHistoryModel
+	Revisions       MovieRevisionModel
+	Posters         PosterModel
+	Releases        ReleaseModel
+	Translations    TranslationModel
+	ExternalIDs     ExternalIDModel
+	Collections     CollectionModel
+	Tags            TagModel
+	Recommendations RecommendationModel
+	Views           ViewModel
 }
 
 // For ease of use, we also add a New() method which returns a Models struct containing
 // the initialized MovieModel.
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Users:       UserModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Permissions: PermissionModel{DB: db},
+		Movies:          MovieModel{DB: db},
+		Users:           UserModel{DB: db},
+		Tokens:          TokenModel{DB: db},
+		Permissions:     PermissionModel{DB: db},
+		Reviews:         ReviewModel{DB: db},
+		People:          PersonModel{DB: db},
+		Credits:         CreditModel{DB: db},
+		Watchlist:       WatchlistModel{DB: db},
+		WatchHistory:    WatchHistoryModel{DB: db},
+		Revisions:       MovieRevisionModel{DB: db},
+		Posters:         PosterModel{DB: db},
+		Releases:        ReleaseModel{DB: db},
+		Translations:    TranslationModel{DB: db},
+		ExternalIDs:     ExternalIDModel{DB: db},
+		Collections:     CollectionModel{DB: db},
+		Tags:            TagModel{DB: db},
+		Recommendations: RecommendationModel{DB: db},
+		Views:           ViewModel{DB: db},
 	}
 }
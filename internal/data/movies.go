@@ -3,6 +3,7 @@ package data
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math"
@@ -21,14 +22,30 @@ type Movie struct {
 	Genres    []string  `json:"genres,omitempty"`  // Slice of genres for the movie (romance, comedy, etc.)
 	Version   int       `json:"version"`           // The version number starts at 1 and will be incremented each
 	// time the movie information is updated
+	AverageRating float64     `json:"average_rating"`       // Mean of all user review ratings (0 if unrated)
+	RatingCount   int         `json:"rating_count"`         // Number of user reviews for the movie
+	DeletedAt     *time.Time  `json:"deleted_at,omitempty"` // When the movie was moved to the trash, nil if it hasn't been
+	Relevance     float64     `json:"-"`                    // How well the movie matched a title search, used for paging
+	Popularity    int64       `json:"-"`                    // Views over the last 7 days, used for paging
+	ExternalIDs   ExternalIDs `json:"-"`                    // Identifiers from other catalogues, only used by imports
+
+	// The fields below are only filled in when the movie has been translated for the
+	// client, in which case Title holds the translated title.
+	OriginalTitle string `json:"original_title,omitempty"`
+	Overview      string `json:"overview,omitempty"`
+	Tagline       string `json:"tagline,omitempty"`
+	Language      string `json:"language,omitempty"` // BCP 47 tag of the translation
 }
 
+// How far ahead an upcoming movie can be catalogued, in years.
+const maxUpcomingYears = 10
+
 func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(movie.Title != "", "title", "must be provided")
 	v.Check(len(movie.Title) <= 500, "title", "must not be more than 500 bytes long")
 	v.Check(movie.Year != 0, "year", "must be provided")
 	v.Check(movie.Year >= 1888, "year", "must be greater than 1888")
-	v.Check(movie.Year <= time.Now().Year(), "year", "must not be in the future")
+	v.Check(movie.Year <= time.Now().Year()+maxUpcomingYears, "year", fmt.Sprintf("must not be more than %d years in the future", maxUpcomingYears))
 	v.Check(movie.Runtime != 0, "runtime", "must be provided")
 	v.Check(movie.Runtime > 0, "runtime", "must be a positive integer")
 	v.Check(movie.Genres != nil, "genres", "must be provided")
@@ -37,13 +54,45 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
 }
 
+// The movieRatingsJoin fragment joins the rating summary for each movie, calculated from
+// the reviews table, onto a query over the movies table. Queries using it should select
+// COALESCE(ratings.average_rating, 0) and COALESCE(ratings.rating_count, 0).
+const movieRatingsJoin = `
+	LEFT JOIN (
+		SELECT movie_id, ROUND(AVG(rating), 1)::float8 AS average_rating, COUNT(*) AS rating_count
+		FROM reviews
+		GROUP BY movie_id
+	) ratings ON ratings.movie_id = movies.id`
+
 // Define a MovieModel struct type which wraps a sql.DB connection pool.
 type MovieModel struct {
 	DB *sql.DB
 }
 
-// Add a placeholder method for inserting a new record in the movies table.
-func (m MovieModel) Insert(movie *Movie) error {
+// Add a placeholder method for inserting a new record in the movies table. The first
+// revision of the movie is recorded against userID in the same transaction.
+func (m MovieModel) Insert(movie *Movie, userID int64) error {
+	// Create a context with a 3-second timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	err = insertMovie(ctx, tx, movie, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The insertMovie() function does the work of Insert() as part of a transaction.
+func insertMovie(ctx context.Context, tx *sql.Tx, movie *Movie, userID int64) error {
 	const query = `
 		INSERT INTO movies (title, year, runtime, genres)
 		VALUES ($1, $2, $3, $4)
@@ -52,11 +101,119 @@ func (m MovieModel) Insert(movie *Movie) error {
 
 	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
 
-	// Create a context with a 3-second timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	if err != nil {
+		return err
+	}
+
+	return insertMovieRevision(ctx, tx, movie, userID, map[string]FieldChange{})
+}
+
+// The copyMovies() function adds a batch of movies as part of a transaction, streaming
+// the rows to PostgreSQL with COPY rather than issuing one INSERT per movie. Note that
+// COPY doesn't report the generated ids back, so the ID, CreatedAt and Version fields
+// of the movies are left untouched.
+func copyMovies(ctx context.Context, tx *sql.Tx, movies []*Movie) error {
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("movies", "title", "year", "runtime", "genres"))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, movie := range movies {
+		_, err = stmt.ExecContext(ctx, movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Calling Exec() with no arguments flushes the buffered rows to the database.
+	_, err = stmt.ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	return stmt.Close()
+}
+
+// An ImportResult counts what happened to the movies in an import.
+type ImportResult struct {
+	Created   int `json:"created"`
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
+}
+
+// An ImportError reports which movie of an import couldn't be written, by its index in
+// the slice passed to Import().
+type ImportError struct {
+	Index int
+	Err   error
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("movie %d: %v", e.Index, e.Err)
+}
+
+func (e *ImportError) Unwrap() error {
+	return e.Err
+}
+
+// Import adds a batch of movies in a single transaction, using COPY for speed. Movies
+// with ExternalIDs are upserted instead: a movie which already has one of the
+// identifiers is updated in place, with its revision recorded against userID, so
+// importing the same feed again doesn't create duplicates. Either every movie is
+// written or none are.
+func (m MovieModel) Import(movies []*Movie, userID int64) (ImportResult, error) {
+	var result ImportResult
+
+	// Use a longer timeout than usual, as an import can contain tens of thousands of
+	// rows.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return result, err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	var plain []*Movie
+	for i, movie := range movies {
+		if len(movie.ExternalIDs) == 0 {
+			plain = append(plain, movie)
+			continue
+		}
+
+		created, changed, err := upsertMovieByExternalIDs(ctx, tx, movie, userID)
+		if err != nil {
+			return ImportResult{}, &ImportError{Index: i, Err: err}
+		}
+
+		switch {
+		case created:
+			result.Created++
+		case changed:
+			result.Updated++
+		default:
+			result.Unchanged++
+		}
+	}
+
+	if len(plain) > 0 {
+		err = copyMovies(ctx, tx, plain)
+		if err != nil {
+			return ImportResult{}, err
+		}
+		result.Created += len(plain)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	return result, nil
 }
 
 // Add a placeholder method for fetching a specific record from the movies table.
@@ -66,9 +223,16 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	}
 
 	const query = `
-		SELECT id, created_at, title, year, runtime, genres, version
+		SELECT movies.id, movies.created_at, title, year, runtime, genres, movies.version,
+			COALESCE(ratings.average_rating, 0), COALESCE(ratings.rating_count, 0)
 		FROM movies
-		WHERE id = $1
+		LEFT JOIN (
+			SELECT movie_id, ROUND(AVG(rating), 1)::float8 AS average_rating, COUNT(*) AS rating_count
+			FROM reviews
+			WHERE movie_id = $1
+			GROUP BY movie_id
+		) ratings ON ratings.movie_id = movies.id
+		WHERE movies.id = $1 AND movies.deleted_at IS NULL
 	`
 
 	// // Update the query to return pg_sleep(10) as the first value.
@@ -94,6 +258,8 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		&movie.Runtime,
 		pq.Array(&movie.Genres),
 		&movie.Version,
+		&movie.AverageRating,
+		&movie.RatingCount,
 	)
 
 	if err != nil {
@@ -108,8 +274,112 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	return &movie, nil
 }
 
-// Add a placeholder method for updating a specific record in the movies table.
-func (m MovieModel) Update(movie *Movie) error {
+// GetMany fetches several movies in a single query. The movies are returned in the
+// same order as ids, and any ids which don't match a movie are left out.
+func (m MovieModel) GetMany(ids []int64) ([]*Movie, error) {
+	const query = `
+		SELECT movies.id, movies.created_at, title, year, runtime, genres, movies.version,
+			COALESCE(ratings.average_rating, 0), COALESCE(ratings.rating_count, 0)
+		FROM movies
+		LEFT JOIN (
+			SELECT movie_id, ROUND(AVG(rating), 1)::float8 AS average_rating, COUNT(*) AS rating_count
+			FROM reviews
+			WHERE movie_id = ANY($1)
+			GROUP BY movie_id
+		) ratings ON ratings.movie_id = movies.id
+		WHERE movies.id = ANY($1) AND movies.deleted_at IS NULL
+		ORDER BY array_position($1, movies.id)
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var movies []*Movie
+
+	for rows.Next() {
+		var movie Movie
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+// Add a placeholder method for updating a specific record in the movies table. The new
+// version is recorded in the movie_revisions table against userID, along with the
+// fields that changed, in the same transaction as the update itself.
+func (m MovieModel) Update(movie *Movie, userID int64) error {
+	// Create a context with a 3-second timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	err = updateMovie(ctx, tx, movie, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The updateMovie() function does the work of Update() as part of a transaction.
+func updateMovie(ctx context.Context, tx *sql.Tx, movie *Movie, userID int64) error {
+	// Lock the row and read the version that is being replaced, so the recorded diff
+	// is against exactly that version. If the version has already moved on then this
+	// is an edit conflict, just like when the UPDATE below matches no rows.
+	var previous Movie
+	err := tx.QueryRowContext(ctx, `
+		SELECT id, title, year, runtime, genres, version
+		FROM movies
+		WHERE id = $1 AND version = $2 AND deleted_at IS NULL
+		FOR UPDATE
+	`, movie.ID, movie.Version).Scan(
+		&previous.ID,
+		&previous.Title,
+		&previous.Year,
+		&previous.Runtime,
+		pq.Array(&previous.Genres),
+		&previous.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
 	const query = `
 		UPDATE movies
 		SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
@@ -125,30 +395,73 @@ func (m MovieModel) Update(movie *Movie) error {
 		movie.Version,
 	}
 
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	// Movies added by a bulk import don't have a revision for their first version, so
+	// record the previous version too if it's missing.
+	err = insertMovieRevision(ctx, tx, &previous, 0, map[string]FieldChange{})
+	if err != nil {
+		return err
+	}
+
+	return insertMovieRevision(ctx, tx, movie, userID, diffMovies(&previous, movie))
+}
+
+// Add a placeholder method for deleting a specific record from the movies table. The
+// movie isn't removed straight away, but moved to the trash by setting its deleted_at
+// timestamp. It can be restored from there until PurgeDeleted() removes it for good.
+func (m MovieModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	const query = `
+		UPDATE movies
+		SET deleted_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL
+	`
+
 	// Create a context with a 3-second timeout.
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
-	switch {
-	case errors.Is(err, sql.ErrNoRows):
-		return ErrEditConflict
-	default:
+
+	res, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
 		return err
 	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
 }
 
-// Add a placeholder method for deleting a specific record from the movies table.
-func (m MovieModel) Delete(id int64) error {
+// Restore takes a movie back out of the trash.
+func (m MovieModel) Restore(id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
 	const query = `
-		DELETE FROM movies
-		WHERE id = $1
+		UPDATE movies
+		SET deleted_at = NULL
+		WHERE id = $1 AND deleted_at IS NOT NULL
 	`
 
-	// Create a context with a 3-second timeout.
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
@@ -169,23 +482,72 @@ func (m MovieModel) Delete(id int64) error {
 	return nil
 }
 
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
-	// Note: PostgreSQL also provides a range of other useful array operators and functions,
-	// including the && ‘overlap’ operator, the <@ ‘contained by’ operator, and the
-	// array_length() function
+// PurgeDeleted permanently removes the movies which have been in the trash for longer
+// than the retention period, and returns how many were removed along with the storage
+// keys of their posters. The poster rows go with the movies, but the images themselves
+// are left for the caller to delete from file storage.
+func (m MovieModel) PurgeDeleted(retention time.Duration) (int64, []string, error) {
+	// Every part of the statement sees the tables as they were before it started, so
+	// the posters of the purged movies can still be read alongside the delete.
+	const query = `
+		WITH purged AS (
+			DELETE FROM movies
+			WHERE deleted_at < $1
+			RETURNING id
+		)
+		SELECT purged.id, movie_posters.storage_key
+		FROM purged
+		LEFT JOIN movie_posters ON movie_posters.movie_id = purged.id
+	`
+
+	// Use a longer timeout than usual, as a purge can remove a lot of rows.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, time.Now().Add(-retention))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	var purged int64
+	var posterKeys []string
+
+	for rows.Next() {
+		var id int64
+		var posterKey sql.NullString
+		err := rows.Scan(&id, &posterKey)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		purged++
+		if posterKey.Valid {
+			posterKeys = append(posterKeys, posterKey.String)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	return purged, posterKeys, nil
+}
+
+// GetAllDeleted returns the movies that are currently in the trash.
+func (m MovieModel) GetAllDeleted(filters Filters) ([]*Movie, Metadata, error) {
 	query := fmt.Sprintf(`
-		SELECT COUNT(*) OVER(), id, created_at, title, year, runtime, genres, version
+		SELECT COUNT(*) OVER(), id, created_at, title, year, runtime, genres, version, deleted_at
 		FROM movies
-		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-		AND (genres @> $2 OR $2 = '{}')
+		WHERE deleted_at IS NOT NULL
 		ORDER BY %s %s, id ASC
-		LIMIT $3 OFFSET $4
+		LIMIT $1 OFFSET $2
 	`, filters.sortColumn(), filters.sortDirection())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query, title, pq.Array(genres), filters.limit(), filters.offset())
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
 	if err != nil {
 		return nil, Metadata{}, err
 	}
@@ -205,6 +567,7 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
 			&movie.Version,
+			&movie.DeletedAt,
 		)
 		if err != nil {
 			return nil, Metadata{}, err
@@ -222,13 +585,377 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	return movies, metadata, nil
 }
 
+// A MovieFilter holds the conditions a movie must meet to be included in a list or an
+// export. Zero values mean the condition isn't applied.
+type MovieFilter struct {
+	Title         string    // Search text matched against the title
+	Genres        []string  // The movie must have all of these genres
+	GenresAny     []string  // The movie must have at least one of these genres
+	GenresNot     []string  // The movie must have none of these genres
+	YearMin       int       // Earliest release year, inclusive
+	YearMax       int       // Latest release year, inclusive
+	RuntimeMin    int       // Shortest runtime in minutes, inclusive
+	RuntimeMax    int       // Longest runtime in minutes, inclusive
+	CreatedAfter  time.Time // Only movies added to the catalogue at or after this time
+	CreatedBefore time.Time // Only movies added to the catalogue before this time
+	PersonID      int64     // The movie must credit this person
+	Role          string    // The movie must have a credit with this role
+
+	ReleasedIn       string // The movie must have a release, past or upcoming, in this country
+	ReleasedOnly     bool   // Only count releases in ReleasedIn which have already happened
+	CertificationMax string // The most restrictive certification allowed in ReleasedIn
+
+	Tags []string // The movie must have been given all of these user tags
+}
+
+func ValidateMovieFilter(v *validator.Validator, f MovieFilter) {
+	maxYear := time.Now().Year() + maxUpcomingYears
+
+	v.Check(f.YearMin >= 0, "year_min", "must not be negative")
+	v.Check(f.YearMin <= maxYear, "year_min", fmt.Sprintf("must not be more than %d years in the future", maxUpcomingYears))
+	v.Check(f.YearMax >= 0, "year_max", "must not be negative")
+	v.Check(f.YearMax <= maxYear, "year_max", fmt.Sprintf("must not be more than %d years in the future", maxUpcomingYears))
+	v.Check(f.YearMin == 0 || f.YearMax == 0 || f.YearMin <= f.YearMax, "year_max", "must not be before year_min")
+
+	v.Check(f.RuntimeMin >= 0, "runtime_min", "must not be negative")
+	v.Check(f.RuntimeMax >= 0, "runtime_max", "must not be negative")
+	v.Check(f.RuntimeMin == 0 || f.RuntimeMax == 0 || f.RuntimeMin <= f.RuntimeMax, "runtime_max", "must not be less than runtime_min")
+
+	v.Check(len(f.GenresAny) <= 5, "genres_any", "must not contain more than 5 genres")
+	v.Check(validator.Unique(f.GenresAny), "genres_any", "must not contain duplicate values")
+	v.Check(len(f.GenresNot) <= 5, "genres_not", "must not contain more than 5 genres")
+	v.Check(validator.Unique(f.GenresNot), "genres_not", "must not contain duplicate values")
+
+	v.Check(f.CreatedAfter.IsZero() || f.CreatedBefore.IsZero() || f.CreatedAfter.Before(f.CreatedBefore), "created_before", "must be after created_after")
+
+	v.Check(f.PersonID >= 0, "person", "must be a positive integer")
+	if f.Role != "" {
+		v.Check(validator.PermittedValue(f.Role, CreditRoles...), "role", "invalid role value")
+	}
+
+	v.Check(len(f.Tags) <= 5, "tags", "must not contain more than 5 tags")
+	v.Check(validator.Unique(f.Tags), "tags", "must not contain duplicate values")
+
+	if f.ReleasedIn != "" {
+		v.Check(validator.Matches(f.ReleasedIn, CountryCodeRX), "released_in", "must be a two letter ISO 3166-1 country code")
+	}
+	if f.ReleasedOnly {
+		v.Check(f.ReleasedIn != "", "released_only", "must be used together with released_in")
+	}
+	if f.CertificationMax != "" {
+		v.Check(f.ReleasedIn != "", "certification_max", "must be used together with released_in")
+		_, ok := certificationsUpTo(f.ReleasedIn, f.CertificationMax)
+		v.Check(f.ReleasedIn == "" || ok, "certification_max", "must be a known certification for the released_in country")
+	}
+}
+
+// The conditions() method returns the SQL conditions, and their arguments, which select
+// the movies matching the filter. Only the conditions for the filters that are set are
+// included.
+func (f MovieFilter) conditions() *sqlConditions {
+	c := &sqlConditions{}
+
+	c.add("movies.deleted_at IS NULL")
+
+	if f.Title != "" {
+		c.add(titleSearchCondition(c.arg(f.Title), c.arg(prefixTSQuery(f.Title))))
+	}
+
+	// Note: PostgreSQL also provides a range of other useful array operators and
+	// functions, including the && ‘overlap’ operator, the <@ ‘contained by’ operator,
+	// and the array_length() function
+	if len(f.Genres) > 0 {
+		c.add("genres @> " + c.arg(pq.Array(f.Genres)))
+	}
+	if len(f.GenresAny) > 0 {
+		c.add("genres && " + c.arg(pq.Array(f.GenresAny)))
+	}
+	if len(f.GenresNot) > 0 {
+		c.add("NOT genres && " + c.arg(pq.Array(f.GenresNot)))
+	}
+
+	if f.YearMin > 0 {
+		c.add("year >= " + c.arg(f.YearMin))
+	}
+	if f.YearMax > 0 {
+		c.add("year <= " + c.arg(f.YearMax))
+	}
+	if f.RuntimeMin > 0 {
+		c.add("runtime >= " + c.arg(f.RuntimeMin))
+	}
+	if f.RuntimeMax > 0 {
+		c.add("runtime <= " + c.arg(f.RuntimeMax))
+	}
+
+	if !f.CreatedAfter.IsZero() {
+		c.add("movies.created_at >= " + c.arg(f.CreatedAfter))
+	}
+	if !f.CreatedBefore.IsZero() {
+		c.add("movies.created_at < " + c.arg(f.CreatedBefore))
+	}
+
+	if f.PersonID != 0 || f.Role != "" {
+		credit := &sqlConditions{args: c.args}
+		credit.add("movie_credits.movie_id = movies.id")
+		if f.PersonID != 0 {
+			credit.add("movie_credits.person_id = " + credit.arg(f.PersonID))
+		}
+		if f.Role != "" {
+			credit.add("movie_credits.role = " + credit.arg(f.Role))
+		}
+		c.args = credit.args
+		c.add(fmt.Sprintf("EXISTS (SELECT 1 FROM movie_credits WHERE %s)", credit.where()))
+	}
+
+	if f.ReleasedIn != "" {
+		release := &sqlConditions{args: c.args}
+		release.add("movie_releases.movie_id = movies.id")
+		release.add("movie_releases.country = " + release.arg(f.ReleasedIn))
+		if f.ReleasedOnly {
+			release.add("movie_releases.release_date <= CURRENT_DATE")
+		}
+		if certifications, ok := certificationsUpTo(f.ReleasedIn, f.CertificationMax); ok {
+			release.add("movie_releases.certification = ANY(" + release.arg(pq.Array(certifications)) + ")")
+		}
+		c.args = release.args
+		c.add(fmt.Sprintf("EXISTS (SELECT 1 FROM movie_releases WHERE %s)", release.where()))
+	}
+
+	// A movie matches when each of the tags has been given to it by at least one user.
+	if len(f.Tags) > 0 {
+		c.add(fmt.Sprintf(`movies.id IN (
+			SELECT movie_id FROM movie_tags WHERE tag = ANY(%s) GROUP BY movie_id HAVING COUNT(DISTINCT tag) = %s
+		)`, c.arg(pq.Array(f.Tags)), c.arg(len(f.Tags))))
+	}
+
+	return c
+}
+
+// GetAll returns a page of the movies matching the filter. If facets are given, the
+// number of matching movies for each value of those facets is calculated in the same
+// query and returned in the metadata. The counts come back with the rows, so a page
+// with no movies on it has no facet counts either.
+func (m MovieModel) GetAll(filter MovieFilter, facets []string, filters Filters) ([]*Movie, Metadata, error) {
+	// In cursor mode we skip the total record count. Calculating it means visiting every
+	// matching row on each request, which is exactly the cost keyset paging avoids.
+	totalRecordsColumn := "COUNT(*) OVER()"
+	if filters.Cursor != "" {
+		totalRecordsColumn = "0"
+	}
+
+	conditions := filter.conditions()
+
+	relevance := "0"
+	if filter.Title != "" {
+		relevance = titleRelevance(conditions.arg(filter.Title), conditions.arg(prefixTSQuery(filter.Title)))
+	}
+
+	// Counting the views of every matching movie is only worth it when sorting by them.
+	popularity := "0"
+	if filters.sortColumn() == "popularity" {
+		popularity = moviePopularity
+	}
+
+	// We ask for one more row than the page size, so we know whether there is a next
+	// page to hand out a cursor for.
+	limit := conditions.arg(filters.limit() + 1)
+	offset := conditions.arg(filters.offset())
+
+	cursorCondition, cursorArgs, err := filters.cursorCondition(len(conditions.args) + 1)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	args := append(conditions.args, cursorArgs...)
+
+	// The filtered movies are wrapped in a common table expression so that every
+	// sortable value, including the rating summary joined in from the reviews table, is
+	// a plain column that both the ORDER BY clause and the cursor condition can refer
+	// to. The facet counts are calculated from it too.
+	query := fmt.Sprintf(`
+		WITH filtered AS (
+			SELECT movies.id, movies.created_at, title, year, runtime, genres, movies.version,
+				COALESCE(ratings.average_rating, 0) AS rating, COALESCE(ratings.rating_count, 0) AS rating_count,
+				%s AS relevance, %s AS popularity
+			FROM movies
+			%s
+			WHERE %s
+		)
+		SELECT %s, id, created_at, title, year, runtime, genres, version, rating, rating_count, relevance, popularity, %s
+		FROM filtered
+		WHERE %s
+		ORDER BY %s %s, id ASC
+		LIMIT %s OFFSET %s
+	`, relevance, popularity, movieRatingsJoin, conditions.where(), totalRecordsColumn, movieFacetsColumn(facets),
+		cursorCondition, filters.sortColumn(), filters.sortDirection(), limit, offset)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	var totalRecords int
+	var facetCounts []byte
+	var movies []*Movie
+
+	for rows.Next() {
+		var movie Movie
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
+			&movie.Relevance,
+			&movie.Popularity,
+			&facetCounts,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	var nextCursor string
+	if len(movies) > filters.limit() {
+		movies = movies[:filters.limit()]
+		last := movies[len(movies)-1]
+		nextCursor = filters.nextCursor(movieSortValue(last, filters.sortColumn()), last.ID)
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	if filters.Cursor != "" {
+		metadata = Metadata{PageSize: filters.PageSize}
+	}
+	metadata.NextCursor = nextCursor
+
+	if facetCounts != nil {
+		err = json.Unmarshal(facetCounts, &metadata.Facets)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+	}
+
+	return movies, metadata, nil
+}
+
+// Export calls fn for every movie matching the filter, in id order.
+// Rather than loading the matching movies into memory, the rows are read through a
+// server-side cursor in batches, so the memory used doesn't grow with the size of the
+// catalogue. The export runs until it is finished or ctx is cancelled, and stops early
+// if fn returns an error.
+func (m MovieModel) Export(ctx context.Context, filter MovieFilter, fn func(*Movie) error) error {
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	conditions := filter.conditions()
+
+	query := fmt.Sprintf(`
+		DECLARE movies_export NO SCROLL CURSOR FOR
+		SELECT movies.id, movies.created_at, title, year, runtime, genres, movies.version,
+			COALESCE(ratings.average_rating, 0), COALESCE(ratings.rating_count, 0)
+		FROM movies
+		%s
+		WHERE %s
+		ORDER BY movies.id ASC
+	`, movieRatingsJoin, conditions.where())
+
+	_, err = tx.ExecContext(ctx, query, conditions.args...)
+	if err != nil {
+		return err
+	}
+
+	for {
+		rows, err := tx.QueryContext(ctx, `FETCH 1000 FROM movies_export`)
+		if err != nil {
+			return err
+		}
+
+		fetched := 0
+		for rows.Next() {
+			var movie Movie
+			err := rows.Scan(
+				&movie.ID,
+				&movie.CreatedAt,
+				&movie.Title,
+				&movie.Year,
+				&movie.Runtime,
+				pq.Array(&movie.Genres),
+				&movie.Version,
+				&movie.AverageRating,
+				&movie.RatingCount,
+			)
+			if err == nil {
+				err = fn(&movie)
+			}
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			fetched++
+		}
+
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+
+		if fetched == 0 {
+			break
+		}
+	}
+
+	return tx.Commit()
+}
+
+// The movieSortValue() function returns the value of the given sort column for a movie,
+// which is what gets recorded in the cursor for the next page.
+func movieSortValue(movie *Movie, column string) any {
+	switch column {
+	case "title":
+		return movie.Title
+	case "year":
+		return movie.Year
+	case "runtime":
+		return int(movie.Runtime)
+	case "rating":
+		return movie.AverageRating
+	case "relevance":
+		return movie.Relevance
+	case "popularity":
+		return movie.Popularity
+	default:
+		return movie.ID
+	}
+}
+
 // Define a new Metadata struct for holding the pagination metadata.
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int                     `json:"current_page,omitempty"`
+	PageSize     int                     `json:"page_size,omitempty"`
+	FirstPage    int                     `json:"first_page,omitempty"`
+	LastPage     int                     `json:"last_page,omitempty"`
+	TotalRecords int                     `json:"total_records,omitempty"`
+	NextCursor   string                  `json:"next_cursor,omitempty"` // Cursor for the following page, if there is one
+	Facets       map[string][]FacetCount `json:"facets,omitempty"`      // Counts of matching movies by facet value, if requested
 }
 
 // The calculateMetadata() function calculates the appropriate pagination metadata
@@ -65,7 +65,7 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 
 func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
 	const query = `
-		INSERT INTO usres_permissions
+		INSERT INTO users_permissions
 		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
 	`
 
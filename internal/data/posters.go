@@ -0,0 +1,117 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// A Poster describes the poster image uploaded for a movie. The image itself is kept in
+// file storage rather than in the database.
+type Poster struct {
+	MovieID     int64     `json:"movie_id"`
+	UploadedAt  time.Time `json:"uploaded_at"`
+	ContentType string    `json:"content_type"`
+	Width       int       `json:"width"`
+	Height      int       `json:"height"`
+	Size        int64     `json:"size"`    // Size of the original image in bytes
+	Version     int       `json:"version"` // Incremented each time the poster is replaced
+	StorageKey  string    `json:"-"`       // Prefix of the keys the image and its variants are stored under
+}
+
+type PosterModel struct {
+	DB *sql.DB
+}
+
+// Upsert records a newly uploaded poster for a movie, replacing any earlier one, and
+// returns the storage key of the poster it replaced, if there was one. The UploadedAt
+// and Version fields are filled in from the database. The movie is locked while the
+// poster is replaced, so concurrent uploads each see the poster they replace and one of
+// them ends up as the poster in full.
+func (m PosterModel) Upsert(poster *Poster) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	var movieID int64
+	err = tx.QueryRowContext(ctx, `SELECT id FROM movies WHERE id = $1 FOR UPDATE`, poster.MovieID).Scan(&movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+
+	var previousKey string
+	err = tx.QueryRowContext(ctx, `SELECT storage_key FROM movie_posters WHERE movie_id = $1`, poster.MovieID).Scan(&previousKey)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	const query = `
+		INSERT INTO movie_posters (movie_id, content_type, width, height, size, storage_key)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (movie_id) DO UPDATE
+		SET uploaded_at = NOW(), content_type = EXCLUDED.content_type, width = EXCLUDED.width,
+			height = EXCLUDED.height, size = EXCLUDED.size, storage_key = EXCLUDED.storage_key,
+			version = movie_posters.version + 1
+		RETURNING uploaded_at, version
+	`
+
+	args := []any{poster.MovieID, poster.ContentType, poster.Width, poster.Height, poster.Size, poster.StorageKey}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&poster.UploadedAt, &poster.Version)
+	if err != nil {
+		return "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", err
+	}
+
+	return previousKey, nil
+}
+
+func (m PosterModel) Get(movieID int64) (*Poster, error) {
+	const query = `
+		SELECT movie_id, uploaded_at, content_type, width, height, size, version, storage_key
+		FROM movie_posters
+		WHERE movie_id = $1
+	`
+
+	var poster Poster
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, movieID).Scan(
+		&poster.MovieID,
+		&poster.UploadedAt,
+		&poster.ContentType,
+		&poster.Width,
+		&poster.Height,
+		&poster.Size,
+		&poster.Version,
+		&poster.StorageKey,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &poster, nil
+}
@@ -0,0 +1,273 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The sources a user's recommendations can come from: their own history, or the most
+// popular movies when there isn't enough history to go on yet.
+const (
+	RecommendationsPersonalized = "personalized"
+	RecommendationsPopular      = "popular"
+)
+
+// The number of similar movies kept for each movie when the model is rebuilt, and the
+// number of users two movies need in common before their similarity is trusted.
+const (
+	similarMoviesKept    = 50
+	similarMoviesSupport = 2
+)
+
+// Define a custom ErrRebuildInProgress error, returned when another server is already
+// rebuilding the recommendations model.
+var (
+	ErrRebuildInProgress = errors.New("recommendations rebuild already in progress")
+)
+
+// A Recommendation is a movie suggested to a user, with a score saying how strongly it
+// is recommended. Scores are only comparable within one list of recommendations.
+type Recommendation struct {
+	Score float64 `json:"score"`
+	Movie *Movie  `json:"movie"`
+}
+
+// The userInterest() function returns a query giving the interest of users in movies,
+// from their reviews, watchlists and watch history, as a weight between -1 and 1. A
+// rating says the most, from -0.8 for a rating of 1 up to 1 for a 10, and overrides
+// anything else. Otherwise adding a movie to a watchlist counts 0.5 and watching it
+// 0.6. The condition, which can refer to user_id and movie_id, is applied to each
+// source.
+func userInterest(condition string) string {
+	return fmt.Sprintf(`
+		SELECT user_id, movie_id, COALESCE(MAX(rated), MAX(implicit)) AS weight
+		FROM (
+			SELECT user_id, movie_id, (rating - 5) / 5.0 AS rated, NULL::numeric AS implicit FROM reviews WHERE %[1]s
+			UNION ALL
+			SELECT user_id, movie_id, NULL, 0.5 FROM watchlist_items WHERE %[1]s
+			UNION ALL
+			SELECT user_id, movie_id, NULL, 0.6 FROM watch_history WHERE %[1]s
+		) interactions
+		GROUP BY user_id, movie_id`, condition)
+}
+
+type RecommendationModel struct {
+	DB *sql.DB
+}
+
+// Rebuild recalculates the item-based collaborative filtering model behind the
+// recommendations and stores it in the movie_similarities table. Each movie is treated
+// as a vector of the positive interest users have shown in it, and two movies are as
+// similar as the cosine of the angle between their vectors. Only the closest movies to
+// each movie are kept. The table is replaced in a single transaction, so requests keep
+// using the old model until the new one is ready. It returns the number of
+// similarities stored. Only one server rebuilds at a time; the others get
+// ErrRebuildInProgress. The rebuild is abandoned if ctx is cancelled.
+func (m RecommendationModel) Rebuild(ctx context.Context) (int64, error) {
+	query := fmt.Sprintf(`
+		WITH interest AS (
+			SELECT user_id, movie_id, weight::float8 AS weight
+			FROM (%s) interest
+			WHERE weight > 0
+		),
+		norms AS (
+			SELECT movie_id, sqrt(SUM(weight * weight)) AS norm
+			FROM interest
+			GROUP BY movie_id
+		),
+		pairs AS (
+			SELECT a.movie_id, b.movie_id AS similar_movie_id, SUM(a.weight * b.weight) AS dot
+			FROM interest a
+			INNER JOIN interest b ON b.user_id = a.user_id AND b.movie_id <> a.movie_id
+			GROUP BY a.movie_id, b.movie_id
+			HAVING COUNT(*) >= $2
+		),
+		ranked AS (
+			SELECT pairs.movie_id, pairs.similar_movie_id, pairs.dot / (a.norm * b.norm) AS score,
+				row_number() OVER (PARTITION BY pairs.movie_id ORDER BY pairs.dot / (a.norm * b.norm) DESC, pairs.similar_movie_id) AS rank
+			FROM pairs
+			INNER JOIN norms a ON a.movie_id = pairs.movie_id
+			INNER JOIN norms b ON b.movie_id = pairs.similar_movie_id
+		)
+		INSERT INTO movie_similarities (movie_id, similar_movie_id, score)
+		SELECT movie_id, similar_movie_id, score
+		FROM ranked
+		WHERE rank <= $1
+	`, userInterest("TRUE"))
+
+	// Rebuilding compares every pair of movies with users in common, so it gets far
+	// longer than a request would.
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	// Servers rebuilding at the same time would each insert the whole model, and all
+	// but the first would fail on the primary key. The lock is held until the
+	// transaction ends.
+	var locked bool
+	err = tx.QueryRowContext(ctx, `SELECT pg_try_advisory_xact_lock(hashtext('movie_similarities'))`).Scan(&locked)
+	if err != nil {
+		return 0, err
+	}
+
+	if !locked {
+		return 0, ErrRebuildInProgress
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM movie_similarities`)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.ExecContext(ctx, query, similarMoviesKept, similarMoviesSupport)
+	if err != nil {
+		return 0, err
+	}
+
+	stored, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return stored, nil
+}
+
+// GetForUser returns a page of recommendations for a user, best first, along with
+// where they came from. Each movie the user has shown an interest in votes for the
+// movies similar to it, weighted by how much they liked it, and movies they already
+// know about are left out. Users without enough history for that to turn anything up
+// get the most popular movies instead.
+func (m RecommendationModel) GetForUser(userID int64, filters Filters) ([]*Recommendation, Metadata, string, error) {
+	interest := userInterest("user_id = $1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Decide where the recommendations come from up front, rather than from the page of
+	// results, so that every page of the list comes from the same source.
+	var personalized bool
+	err := m.DB.QueryRowContext(ctx, fmt.Sprintf(`
+		WITH interest AS (%s)
+		SELECT EXISTS (
+			SELECT 1
+			FROM interest
+			INNER JOIN movie_similarities ON movie_similarities.movie_id = interest.movie_id
+			WHERE interest.weight > 0
+			AND movie_similarities.similar_movie_id NOT IN (SELECT movie_id FROM interest)
+		)
+	`, interest), userID).Scan(&personalized)
+	if err != nil {
+		return nil, Metadata{}, "", err
+	}
+
+	source := RecommendationsPersonalized
+	query := fmt.Sprintf(`
+		WITH interest AS (%s),
+		scores AS (
+			SELECT movie_similarities.similar_movie_id AS movie_id,
+				SUM(movie_similarities.score * interest.weight::float8) AS score
+			FROM interest
+			INNER JOIN movie_similarities ON movie_similarities.movie_id = interest.movie_id
+			WHERE movie_similarities.similar_movie_id NOT IN (SELECT movie_id FROM interest)
+			GROUP BY movie_similarities.similar_movie_id
+			HAVING SUM(movie_similarities.score * interest.weight::float8) > 0
+		)
+		SELECT COUNT(*) OVER(), ROUND(scores.score::numeric, 3)::float8,
+			movies.id, movies.created_at, title, year, runtime, genres, movies.version,
+			COALESCE(ratings.average_rating, 0), COALESCE(ratings.rating_count, 0)
+		FROM scores
+		INNER JOIN movies ON movies.id = scores.movie_id
+		%s
+		WHERE movies.deleted_at IS NULL
+		ORDER BY scores.score DESC, movies.id ASC
+		LIMIT $2 OFFSET $3
+	`, interest, movieRatingsJoin)
+
+	// The popular movies are those the most users have shown an interest in over the
+	// last 90 days, with the best rated first among equals, so even a catalogue nobody
+	// has used yet has something to show.
+	if !personalized {
+		source = RecommendationsPopular
+		query = fmt.Sprintf(`
+			WITH interest AS (%s),
+			popularity AS (
+				SELECT movie_id, COUNT(DISTINCT user_id) AS users
+				FROM (
+					SELECT user_id, movie_id FROM reviews WHERE created_at > NOW() - INTERVAL '90 days'
+					UNION ALL
+					SELECT user_id, movie_id FROM watchlist_items WHERE added_at > NOW() - INTERVAL '90 days'
+					UNION ALL
+					SELECT user_id, movie_id FROM watch_history WHERE watched_at > NOW() - INTERVAL '90 days'
+				) recent
+				GROUP BY movie_id
+			)
+			SELECT COUNT(*) OVER(), COALESCE(popularity.users, 0)::float8,
+				movies.id, movies.created_at, title, year, runtime, genres, movies.version,
+				COALESCE(ratings.average_rating, 0), COALESCE(ratings.rating_count, 0)
+			FROM movies
+			LEFT JOIN popularity ON popularity.movie_id = movies.id
+			%s
+			WHERE movies.deleted_at IS NULL
+			AND movies.id NOT IN (SELECT movie_id FROM interest)
+			ORDER BY COALESCE(popularity.users, 0) DESC, COALESCE(ratings.average_rating, 0) DESC, movies.id ASC
+			LIMIT $2 OFFSET $3
+		`, interest, movieRatingsJoin)
+	}
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, "", err
+	}
+	defer rows.Close()
+
+	var totalRecords int
+	var recommendations []*Recommendation
+
+	for rows.Next() {
+		var recommendation Recommendation
+		var movie Movie
+		err := rows.Scan(
+			&totalRecords,
+			&recommendation.Score,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
+		)
+		if err != nil {
+			return nil, Metadata{}, "", err
+		}
+
+		recommendation.Movie = &movie
+		recommendations = append(recommendations, &recommendation)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, "", err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return recommendations, metadata, source, nil
+}
@@ -0,0 +1,172 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/startdusk/greenlight/internal/validator"
+)
+
+// The ways a movie can be released. These mirror the movie_releases_release_type_check
+// constraint in the database.
+var ReleaseTypes = []string{"theatrical", "digital", "physical", "tv"}
+
+// The age certifications used by the rating boards we know about, keyed by ISO 3166-1
+// country code and ordered from the least to the most restrictive. Releases in other
+// countries can use any certification.
+var Certifications = map[string][]string{
+	"US": {"G", "PG", "PG-13", "R", "NC-17"},
+	"GB": {"U", "PG", "12A", "12", "15", "18", "R18"},
+	"DE": {"FSK0", "FSK6", "FSK12", "FSK16", "FSK18"},
+	"FR": {"U", "10", "12", "16", "18"},
+	"AU": {"G", "PG", "M", "MA15+", "R18+"},
+}
+
+// CountryCodeRX matches ISO 3166-1 alpha-2 country codes, such as "US" or "GB".
+var CountryCodeRX = regexp.MustCompile("^[A-Z]{2}$")
+
+// The certificationsUpTo() function returns the certifications for a country which are
+// no more restrictive than max. It returns false if the country or the certification
+// isn't known.
+func certificationsUpTo(country, max string) ([]string, bool) {
+	for i, certification := range Certifications[country] {
+		if certification == max {
+			return Certifications[country][:i+1], true
+		}
+	}
+	return nil, false
+}
+
+// A ReleaseDate is a calendar date, encoded in JSON as "YYYY-MM-DD".
+type ReleaseDate time.Time
+
+func (d ReleaseDate) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(time.Time(d).Format(time.DateOnly))), nil
+}
+
+func (d *ReleaseDate) UnmarshalJSON(jsonValue []byte) error {
+	unquotedJSONValue, err := strconv.Unquote(string(jsonValue))
+	if err != nil {
+		return fmt.Errorf("release date must be a string in the format YYYY-MM-DD")
+	}
+
+	t, err := time.Parse(time.DateOnly, unquotedJSONValue)
+	if err != nil {
+		return fmt.Errorf("release date must be in the format YYYY-MM-DD")
+	}
+
+	*d = ReleaseDate(t)
+	return nil
+}
+
+// A Release records when and how a movie was (or will be) released in a country, and
+// the age certification it was given there.
+type Release struct {
+	Country       string      `json:"country"` // ISO 3166-1 alpha-2 country code
+	Date          ReleaseDate `json:"date"`
+	Type          string      `json:"type"`
+	Certification string      `json:"certification,omitempty"`
+}
+
+func ValidateReleases(v *validator.Validator, releases []Release) {
+	v.Check(releases != nil, "releases", "must be provided")
+	keys := make([]string, len(releases))
+	for i, release := range releases {
+		key := fmt.Sprintf("releases[%d]", i)
+		v.Check(validator.Matches(release.Country, CountryCodeRX), key, "country must be a two letter ISO 3166-1 country code")
+		v.Check(!time.Time(release.Date).IsZero(), key, "date must be provided")
+		v.Check(validator.PermittedValue(release.Type, ReleaseTypes...), key, "type must be one of "+strings.Join(ReleaseTypes, ", "))
+		v.Check(len(release.Certification) <= 20, key, "certification must not be more than 20 bytes long")
+		if known, ok := Certifications[release.Country]; ok && release.Certification != "" {
+			v.Check(validator.PermittedValue(release.Certification, known...), key, "certification must be one of "+strings.Join(known, ", "))
+		}
+		keys[i] = release.Country + ":" + release.Type
+	}
+	v.Check(validator.Unique(keys), "releases", "must not contain two releases of the same type in one country")
+}
+
+type ReleaseModel struct {
+	DB *sql.DB
+}
+
+// GetAllForMovie returns the releases of a movie, ordered by country and then date.
+func (m ReleaseModel) GetAllForMovie(movieID int64) ([]*Release, error) {
+	const query = `
+		SELECT country, release_date, release_type, certification
+		FROM movie_releases
+		WHERE movie_id = $1
+		ORDER BY country, release_date, release_type
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var releases []*Release
+
+	for rows.Next() {
+		var release Release
+		var date time.Time
+		err := rows.Scan(
+			&release.Country,
+			&date,
+			&release.Type,
+			&release.Certification,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		release.Date = ReleaseDate(date)
+		releases = append(releases, &release)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return releases, nil
+}
+
+// ReplaceForMovie swaps the full set of releases for a movie in a single transaction.
+func (m ReleaseModel) ReplaceForMovie(movieID int64, releases []Release) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM movie_releases WHERE movie_id = $1`, movieID)
+	if err != nil {
+		return err
+	}
+
+	const query = `
+		INSERT INTO movie_releases (movie_id, country, release_type, release_date, certification)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+
+	for _, release := range releases {
+		date := time.Time(release.Date).Format(time.DateOnly)
+		_, err = tx.ExecContext(ctx, query, movieID, release.Country, release.Type, date, release.Certification)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
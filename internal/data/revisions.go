@@ -0,0 +1,196 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// A FieldChange holds the old and new values of a movie field that was changed in a
+// revision.
+type FieldChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// A MovieRevision is a snapshot of a movie as it was at a specific version, along with
+// who made the change and what changed compared to the previous version.
+type MovieRevision struct {
+	MovieID   int64                  `json:"movie_id"`
+	Version   int                    `json:"version"`
+	CreatedAt time.Time              `json:"created_at"`
+	UserID    int64                  `json:"user_id,omitempty"` // Zero when the acting user is unknown
+	Title     string                 `json:"title"`
+	Year      int                    `json:"year"`
+	Runtime   Runtime                `json:"runtime"`
+	Genres    []string               `json:"genres"`
+	Changes   map[string]FieldChange `json:"changes"`
+}
+
+// The diffMovies() function returns the fields which differ between two versions of a
+// movie, keyed by their JSON names.
+func diffMovies(previous, current *Movie) map[string]FieldChange {
+	changes := make(map[string]FieldChange)
+
+	if previous.Title != current.Title {
+		changes["title"] = FieldChange{From: previous.Title, To: current.Title}
+	}
+	if previous.Year != current.Year {
+		changes["year"] = FieldChange{From: previous.Year, To: current.Year}
+	}
+	if previous.Runtime != current.Runtime {
+		changes["runtime"] = FieldChange{From: previous.Runtime, To: current.Runtime}
+	}
+
+	genresChanged := len(previous.Genres) != len(current.Genres)
+	for i := 0; !genresChanged && i < len(previous.Genres); i++ {
+		genresChanged = previous.Genres[i] != current.Genres[i]
+	}
+	if genresChanged {
+		changes["genres"] = FieldChange{From: previous.Genres, To: current.Genres}
+	}
+
+	return changes
+}
+
+// The insertMovieRevision() function records the current state of a movie as a
+// revision, as part of the transaction which changed it. A userID of zero is stored as
+// an unknown user. If a revision for the version already exists it is left as it is.
+func insertMovieRevision(ctx context.Context, tx *sql.Tx, movie *Movie, userID int64, changes map[string]FieldChange) error {
+	js, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+
+	const query = `
+		INSERT INTO movie_revisions (movie_id, version, user_id, title, year, runtime, genres, changes)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (movie_id, version) DO NOTHING
+	`
+
+	args := []any{
+		movie.ID,
+		movie.Version,
+		sql.NullInt64{Int64: userID, Valid: userID != 0},
+		movie.Title,
+		movie.Year,
+		movie.Runtime,
+		pq.Array(movie.Genres),
+		js,
+	}
+
+	_, err = tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+type MovieRevisionModel struct {
+	DB *sql.DB
+}
+
+func (m MovieRevisionModel) Get(movieID int64, version int) (*MovieRevision, error) {
+	const query = `
+		SELECT movie_id, version, created_at, user_id, title, year, runtime, genres, changes
+		FROM movie_revisions
+		WHERE movie_id = $1 AND version = $2
+	`
+
+	var revision MovieRevision
+	var userID sql.NullInt64
+	var changes []byte
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, version).Scan(
+		&revision.MovieID,
+		&revision.Version,
+		&revision.CreatedAt,
+		&userID,
+		&revision.Title,
+		&revision.Year,
+		&revision.Runtime,
+		pq.Array(&revision.Genres),
+		&changes,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	revision.UserID = userID.Int64
+	err = json.Unmarshal(changes, &revision.Changes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &revision, nil
+}
+
+func (m MovieRevisionModel) GetAllForMovie(movieID int64, filters Filters) ([]*MovieRevision, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), movie_id, version, created_at, user_id, title, year, runtime, genres, changes
+		FROM movie_revisions
+		WHERE movie_id = $1
+		ORDER BY %s %s
+		LIMIT $2 OFFSET $3
+	`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	var totalRecords int
+	var revisions []*MovieRevision
+
+	for rows.Next() {
+		var revision MovieRevision
+		var userID sql.NullInt64
+		var changes []byte
+
+		err := rows.Scan(
+			&totalRecords,
+			&revision.MovieID,
+			&revision.Version,
+			&revision.CreatedAt,
+			&userID,
+			&revision.Title,
+			&revision.Year,
+			&revision.Runtime,
+			pq.Array(&revision.Genres),
+			&changes,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		revision.UserID = userID.Int64
+		err = json.Unmarshal(changes, &revision.Changes)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		revisions = append(revisions, &revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return revisions, metadata, nil
+}
@@ -0,0 +1,114 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The titleSearchCondition() function returns a SQL condition matching movies whose
+// title, or the title of one of their translations, matches a search. It takes the
+// placeholders for the search text and for its prefixTSQuery(). A title matches if it
+// contains every word of the search, with the last word treated as a prefix, or if it
+// is close enough to the search text to be a typo (using the pg_trgm word similarity
+// operator).
+func titleSearchCondition(textArg, queryArg string) string {
+	match := func(column string) string {
+		return fmt.Sprintf("(to_tsvector('simple', %[1]s) @@ to_tsquery('simple', %[3]s) OR %[2]s <%% %[1]s)",
+			column, textArg, queryArg)
+	}
+
+	return fmt.Sprintf(
+		"(%s OR EXISTS (SELECT 1 FROM movie_translations WHERE movie_translations.movie_id = movies.id AND %s))",
+		match("movies.title"), match("movie_translations.title"))
+}
+
+// The titleRelevance() function returns a SQL expression scoring how well a movie
+// matches a search, with the same placeholders as titleSearchCondition(). The best
+// score out of the original title and the translated titles is used. The score is
+// negated, so that sort=relevance lists the best matches first just like the other
+// ascending sorts list the smallest values first.
+func titleRelevance(textArg, queryArg string) string {
+	score := func(column string) string {
+		return fmt.Sprintf("ts_rank(to_tsvector('simple', %[1]s), to_tsquery('simple', %[3]s)) + word_similarity(%[2]s, %[1]s)",
+			column, textArg, queryArg)
+	}
+
+	return fmt.Sprintf(
+		"-GREATEST(%s, COALESCE((SELECT MAX(%s) FROM movie_translations WHERE movie_translations.movie_id = movies.id), 0))::float8",
+		score("movies.title"), score("movie_translations.title"))
+}
+
+// The prefixTSQuery() function turns search text into a tsquery string which matches
+// titles containing all of its words, with the last word matching as a prefix. For
+// example "star wa" becomes "star & wa:*". Only letters and digits are kept, so user
+// input can't inject tsquery operators.
+func prefixTSQuery(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 {
+		return ""
+	}
+
+	words[len(words)-1] += ":*"
+	return strings.Join(words, " & ")
+}
+
+// The likePrefix() function returns a LIKE pattern matching strings which start with s,
+// escaping any wildcard characters in it.
+func likePrefix(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return replacer.Replace(s) + "%"
+}
+
+// A MovieSuggestion is a title completion returned by the autocomplete endpoint.
+type MovieSuggestion struct {
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
+	Year  int    `json:"year,omitempty"`
+}
+
+// Suggest returns up to limit movies whose titles complete the text typed so far.
+// Titles starting with the text come first, followed by titles containing its words or
+// resembling it, ordered by how similar they are.
+func (m MovieModel) Suggest(text string, limit int) ([]*MovieSuggestion, error) {
+	query := fmt.Sprintf(`
+		SELECT id, title, year
+		FROM movies
+		WHERE deleted_at IS NULL
+		AND (title ILIKE $3 OR %s)
+		ORDER BY title ILIKE $3 DESC, word_similarity($1, title) DESC, title ASC, id ASC
+		LIMIT $4
+	`, titleSearchCondition("$1", "$2"))
+
+	// Suggestions are fetched as the user types, so a slow answer is no use to anyone.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, text, prefixTSQuery(text), likePrefix(text), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var suggestions []*MovieSuggestion
+
+	for rows.Next() {
+		var suggestion MovieSuggestion
+		err := rows.Scan(&suggestion.ID, &suggestion.Title, &suggestion.Year)
+		if err != nil {
+			return nil, err
+		}
+
+		suggestions = append(suggestions, &suggestion)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return suggestions, nil
+}
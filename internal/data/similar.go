@@ -0,0 +1,150 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// A SimilarMovie is a movie recommended as being like another one, with a score
+// between 0 and 1 saying how alike they are.
+type SimilarMovie struct {
+	Score float64 `json:"score"`
+	Movie *Movie  `json:"movie"`
+}
+
+// The similarMoviesQuery scores every candidate movie against the target movie $1 on
+// four signals, each scaled to between 0 and 1:
+//
+//   - genres: the Jaccard index of the two sets of genres
+//   - credits: the number of people credited on both, counting up to 5
+//   - audience: how many users who showed an interest in the target movie (by adding
+//     it to their watchlist, watching it or rating it 7 or more) showed an interest in
+//     the candidate too, relative to the best candidate
+//   - title: the trigram similarity of the titles, which picks up sequels
+//
+// and weights them 40/30/20/10 into the final score. Signals without any data behind
+// them, like the audience of a brand new catalogue, simply score zero. Only movies
+// which share something with the target are considered, so the whole catalogue isn't
+// scored on every request.
+const similarMoviesQuery = `
+	WITH target AS (
+		SELECT id, title, genres
+		FROM movies
+		WHERE id = $1
+	),
+	shared_credits AS (
+		SELECT other.movie_id, COUNT(DISTINCT other.person_id) AS n
+		FROM movie_credits own
+		INNER JOIN movie_credits other ON other.person_id = own.person_id AND other.movie_id <> own.movie_id
+		WHERE own.movie_id = $1
+		GROUP BY other.movie_id
+	),
+	audience AS (
+		SELECT user_id FROM watchlist_items WHERE movie_id = $1
+		UNION
+		SELECT user_id FROM watch_history WHERE movie_id = $1
+		UNION
+		SELECT user_id FROM reviews WHERE movie_id = $1 AND rating >= 7
+	),
+	shared_audience AS (
+		SELECT interest.movie_id, COUNT(DISTINCT interest.user_id) AS n
+		FROM (
+			SELECT user_id, movie_id FROM watchlist_items
+			UNION ALL
+			SELECT user_id, movie_id FROM watch_history
+			UNION ALL
+			SELECT user_id, movie_id FROM reviews WHERE rating >= 7
+		) interest
+		WHERE interest.user_id IN (SELECT user_id FROM audience) AND interest.movie_id <> $1
+		GROUP BY interest.movie_id
+	),
+	signals AS (
+		SELECT movies.id, movies.created_at, movies.title, movies.year, movies.runtime, movies.genres, movies.version,
+			COALESCE(
+				cardinality(ARRAY(SELECT unnest(movies.genres) INTERSECT SELECT unnest(target.genres)))::float8 /
+				NULLIF(cardinality(ARRAY(SELECT unnest(movies.genres) UNION SELECT unnest(target.genres))), 0),
+				0
+			) AS genres_score,
+			LEAST(COALESCE(shared_credits.n, 0), 5) / 5.0 AS credits_score,
+			COALESCE(shared_audience.n::float8 / NULLIF(MAX(shared_audience.n) OVER (), 0), 0) AS audience_score,
+			similarity(movies.title, target.title) AS title_score
+		FROM movies
+		CROSS JOIN target
+		LEFT JOIN shared_credits ON shared_credits.movie_id = movies.id
+		LEFT JOIN shared_audience ON shared_audience.movie_id = movies.id
+		WHERE movies.id <> target.id AND movies.deleted_at IS NULL
+		AND (
+			movies.genres && target.genres
+			OR shared_credits.n IS NOT NULL
+			OR shared_audience.n IS NOT NULL
+			OR movies.title %% target.title
+		)
+	),
+	scored AS (
+		SELECT *,
+			ROUND((0.4 * genres_score + 0.3 * credits_score + 0.2 * audience_score + 0.1 * title_score)::numeric, 3)::float8 AS score
+		FROM signals
+	)
+	SELECT COUNT(*) OVER(), movies.score, movies.id, movies.created_at, movies.title, movies.year,
+		movies.runtime, movies.genres, movies.version,
+		COALESCE(ratings.average_rating, 0), COALESCE(ratings.rating_count, 0)
+	FROM scored movies
+	%s
+	WHERE movies.score > 0
+	ORDER BY movies.score DESC, movies.id ASC
+	LIMIT $2 OFFSET $3
+`
+
+// GetSimilar returns a page of the movies most like the given one, best match first.
+// The sort in filters is ignored, as the results are always ordered by score.
+func (m MovieModel) GetSimilar(movieID int64, filters Filters) ([]*SimilarMovie, Metadata, error) {
+	query := fmt.Sprintf(similarMoviesQuery, movieRatingsJoin)
+
+	// Scoring touches far more rows than a normal lookup, so allow it a little longer.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	var totalRecords int
+	var similar []*SimilarMovie
+
+	for rows.Next() {
+		var item SimilarMovie
+		var movie Movie
+		err := rows.Scan(
+			&totalRecords,
+			&item.Score,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		item.Movie = &movie
+		similar = append(similar, &item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return similar, metadata, nil
+}
@@ -0,0 +1,329 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/startdusk/greenlight/internal/validator"
+)
+
+// TagRX matches the free-form tags users can give movies, such as "comfort watch" or
+// "date night", once they have been normalized with NormalizeTag(). Tags start with a
+// letter or digit, and end with one or with a combining mark such as a Devanagari vowel
+// sign. They can contain spaces, hyphens, apostrophes and ampersands in between.
+// Modifier letters, like the Japanese long vowel mark "ー", count as letters.
+var TagRX = regexp.MustCompile(`^[\p{Ll}\p{Lm}\p{Lo}\p{N}]([\p{Ll}\p{Lm}\p{Lo}\p{N}\p{Mn}\p{Mc} '&-]*[\p{Ll}\p{Lm}\p{Lo}\p{N}\p{Mn}\p{Mc}])?$`)
+
+// Define a custom ErrBannedTag error, returned when a user tries to use a tag which a
+// moderator has banned.
+var (
+	ErrBannedTag = errors.New("banned tag")
+)
+
+// A TagCount reports how many times a tag has been given, either to one movie or
+// across the whole catalogue.
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+	Mine  bool   `json:"mine,omitempty"` // Whether the current user gave the tag, only populated for a single movie
+}
+
+// A BannedTag is a tag which moderators have removed and which can't be used again.
+type BannedTag struct {
+	Tag      string    `json:"tag"`
+	BannedAt time.Time `json:"banned_at"`
+	BannedBy int64     `json:"banned_by,omitempty"` // ID of the moderator, zero if their account has since been deleted
+	Reason   string    `json:"reason,omitempty"`
+}
+
+// NormalizeTag lower cases a tag and collapses any runs of whitespace in it into a
+// single space, so that "Date  Night" and "date night" are the same tag.
+func NormalizeTag(tag string) string {
+	return strings.Join(strings.Fields(strings.ToLower(tag)), " ")
+}
+
+func ValidateTag(v *validator.Validator, tag string) {
+	v.Check(tag != "", "tag", "must be provided")
+	v.Check(len(tag) <= 50, "tag", "must not be more than 50 bytes long")
+	v.Check(tag == "" || validator.Matches(tag, TagRX), "tag", "must only contain letters, digits, spaces, hyphens, apostrophes and ampersands")
+}
+
+type TagModel struct {
+	DB *sql.DB
+}
+
+// Add gives a movie a tag on behalf of a user. Adding a tag the user has already given
+// the movie isn't an error, but created is false. It returns ErrBannedTag if the tag
+// has been banned.
+func (m TagModel) Add(movieID, userID int64, tag string) (created bool, err error) {
+	const query = `
+		INSERT INTO movie_tags (movie_id, user_id, tag)
+		SELECT $1, $2, $3
+		WHERE NOT EXISTS (SELECT 1 FROM banned_tags WHERE tag = $3)
+		ON CONFLICT (movie_id, user_id, tag) DO NOTHING
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	// Share the tag's lock with other users adding it, so a ban waits for this to finish
+	// and this waits for a ban, rather than the ban missing the new tag.
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock_shared(hashtext('tag:' || $1))`, tag)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := tx.ExecContext(ctx, query, movieID, userID, tag)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	if rowsAffected > 0 {
+		return true, tx.Commit()
+	}
+
+	// Nothing was inserted, either because the user had already given the tag or
+	// because it's banned. Find out which.
+	var banned bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM banned_tags WHERE tag = $1)`, tag).Scan(&banned)
+	if err != nil {
+		return false, err
+	}
+
+	if banned {
+		return false, ErrBannedTag
+	}
+
+	return false, tx.Commit()
+}
+
+// Remove takes a tag the user gave a movie away again.
+func (m TagModel) Remove(movieID, userID int64, tag string) error {
+	const query = `
+		DELETE FROM movie_tags
+		WHERE movie_id = $1 AND user_id = $2 AND tag = $3
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, movieID, userID, tag)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetAllForMovie returns the tags given to a movie with the number of users who gave
+// each one, most popular first. Tags given by userID are marked as theirs.
+func (m TagModel) GetAllForMovie(movieID, userID int64) ([]*TagCount, error) {
+	const query = `
+		SELECT tag, COUNT(*), bool_or(user_id = $2)
+		FROM movie_tags
+		WHERE movie_id = $1
+		GROUP BY tag
+		ORDER BY COUNT(*) DESC, tag
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []*TagCount
+
+	for rows.Next() {
+		var tag TagCount
+		err := rows.Scan(&tag.Tag, &tag.Count, &tag.Mine)
+		if err != nil {
+			return nil, err
+		}
+
+		tags = append(tags, &tag)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// GetPopular returns the most used tags across the catalogue, optionally only those
+// starting with prefix, along with how many times each has been given. Tags on movies
+// in the trash aren't counted.
+func (m TagModel) GetPopular(prefix string, limit int) ([]*TagCount, error) {
+	const query = `
+		SELECT movie_tags.tag, COUNT(*)
+		FROM movie_tags
+		INNER JOIN movies ON movies.id = movie_tags.movie_id
+		WHERE movies.deleted_at IS NULL AND movie_tags.tag LIKE $1
+		GROUP BY movie_tags.tag
+		ORDER BY COUNT(*) DESC, movie_tags.tag
+		LIMIT $2
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, likePrefix(prefix), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []*TagCount
+
+	for rows.Next() {
+		var tag TagCount
+		err := rows.Scan(&tag.Tag, &tag.Count)
+		if err != nil {
+			return nil, err
+		}
+
+		tags = append(tags, &tag)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// Ban stops a tag being used and removes it from every movie, in a single transaction.
+// Banning a tag again updates the reason. It returns how many times the tag had been
+// given.
+func (m TagModel) Ban(tag string, moderatorID int64, reason string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	// Wait for users who are adding the tag right now, and keep new ones waiting until
+	// the ban is in place. See Add().
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('tag:' || $1))`, tag)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO banned_tags (tag, banned_by, reason)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (tag) DO UPDATE
+		SET banned_at = NOW(), banned_by = EXCLUDED.banned_by, reason = EXCLUDED.reason
+	`, tag, moderatorID, reason)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM movie_tags WHERE tag = $1`, tag)
+	if err != nil {
+		return 0, err
+	}
+
+	removed, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return removed, nil
+}
+
+// Unban allows a banned tag to be used again. Tags removed by the ban aren't restored.
+func (m TagModel) Unban(tag string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, `DELETE FROM banned_tags WHERE tag = $1`, tag)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetAllBanned returns every banned tag, most recently banned first.
+func (m TagModel) GetAllBanned() ([]*BannedTag, error) {
+	const query = `
+		SELECT tag, banned_at, COALESCE(banned_by, 0), reason
+		FROM banned_tags
+		ORDER BY banned_at DESC, tag
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []*BannedTag
+
+	for rows.Next() {
+		var tag BannedTag
+		err := rows.Scan(&tag.Tag, &tag.BannedAt, &tag.BannedBy, &tag.Reason)
+		if err != nil {
+			return nil, err
+		}
+
+		tags = append(tags, &tag)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
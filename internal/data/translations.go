@@ -0,0 +1,243 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/startdusk/greenlight/internal/validator"
+)
+
+// LanguageTagRX matches the BCP 47 language tags we store translations under, such as
+// "fr", "pt-BR" or "zh-Hant". Tags are matched once they have been put into the
+// canonical case they are stored in, with a lower case language, title case script and
+// upper case region, which is why the subtags can contain upper case letters.
+var LanguageTagRX = regexp.MustCompile(`^[a-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// A Translation holds the localized text of a movie in one language.
+type Translation struct {
+	Language string `json:"language"` // BCP 47 language tag
+	Title    string `json:"title"`
+	Overview string `json:"overview,omitempty"`
+	Tagline  string `json:"tagline,omitempty"`
+	Version  int    `json:"version"`
+}
+
+func ValidateTranslation(v *validator.Validator, translation *Translation) {
+	v.Check(validator.Matches(translation.Language, LanguageTagRX), "language", "must be a valid BCP 47 language tag")
+	v.Check(len(translation.Language) <= 35, "language", "must not be more than 35 bytes long")
+	v.Check(translation.Title != "", "title", "must be provided")
+	v.Check(len(translation.Title) <= 500, "title", "must not be more than 500 bytes long")
+	v.Check(len(translation.Overview) <= 5000, "overview", "must not be more than 5000 bytes long")
+	v.Check(len(translation.Tagline) <= 500, "tagline", "must not be more than 500 bytes long")
+}
+
+type TranslationModel struct {
+	DB *sql.DB
+}
+
+func (m TranslationModel) Get(movieID int64, language string) (*Translation, error) {
+	const query = `
+		SELECT language, title, overview, tagline, version
+		FROM movie_translations
+		WHERE movie_id = $1 AND language = $2
+	`
+
+	var translation Translation
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, language).Scan(
+		&translation.Language,
+		&translation.Title,
+		&translation.Overview,
+		&translation.Tagline,
+		&translation.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &translation, nil
+}
+
+// GetAllForMovie returns every translation of a movie, ordered by language.
+func (m TranslationModel) GetAllForMovie(movieID int64) ([]*Translation, error) {
+	const query = `
+		SELECT language, title, overview, tagline, version
+		FROM movie_translations
+		WHERE movie_id = $1
+		ORDER BY language
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var translations []*Translation
+
+	for rows.Next() {
+		var translation Translation
+		err := rows.Scan(
+			&translation.Language,
+			&translation.Title,
+			&translation.Overview,
+			&translation.Tagline,
+			&translation.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		translations = append(translations, &translation)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return translations, nil
+}
+
+// GetBestForMovies picks the translation of each movie which best matches a list of
+// languages in order of preference, keyed by movie ID. Movies without a translation in
+// any of the languages are left out.
+func (m TranslationModel) GetBestForMovies(movieIDs []int64, languages []string) (map[int64]*Translation, error) {
+	const query = `
+		SELECT DISTINCT ON (movie_id) movie_id, language, title, overview, tagline, version
+		FROM movie_translations
+		WHERE movie_id = ANY($1) AND language = ANY($2)
+		ORDER BY movie_id, array_position($2, language)
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs), pq.Array(languages))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	translations := make(map[int64]*Translation)
+
+	for rows.Next() {
+		var movieID int64
+		var translation Translation
+		err := rows.Scan(
+			&movieID,
+			&translation.Language,
+			&translation.Title,
+			&translation.Overview,
+			&translation.Tagline,
+			&translation.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		translations[movieID] = &translation
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return translations, nil
+}
+
+// Upsert creates or replaces the translation of a movie into a language. When replacing
+// a translation, its Version must match the stored one, as with the other updates, and
+// ErrRecordNotFound is returned if there is no translation to replace. Version should
+// be zero to create a new translation, and ErrEditConflict is returned if one already
+// exists. The version is set by the database and copied back into the translation.
+func (m TranslationModel) Upsert(movieID int64, translation *Translation) error {
+	args := []any{movieID, translation.Language, translation.Title, translation.Overview, translation.Tagline}
+
+	query := `
+		INSERT INTO movie_translations (movie_id, language, title, overview, tagline)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (movie_id, language) DO NOTHING
+		RETURNING version
+	`
+
+	if translation.Version != 0 {
+		query = `
+			UPDATE movie_translations
+			SET title = $3, overview = $4, tagline = $5, version = version + 1
+			WHERE movie_id = $1 AND language = $2 AND version = $6
+			RETURNING version
+		`
+		args = append(args, translation.Version)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&translation.Version)
+	switch {
+	case err == nil:
+		return nil
+	case !errors.Is(err, sql.ErrNoRows):
+		return err
+	case translation.Version == 0:
+		// Nothing was inserted, so the translation already exists.
+		return ErrEditConflict
+	}
+
+	// The update didn't match, either because the translation has changed since the
+	// client read it or because there is no translation to replace. Find out which.
+	var exists bool
+	err = m.DB.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM movie_translations WHERE movie_id = $1 AND language = $2)
+	`, movieID, translation.Language).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return ErrRecordNotFound
+	}
+
+	return ErrEditConflict
+}
+
+func (m TranslationModel) Delete(movieID int64, language string) error {
+	const query = `
+		DELETE FROM movie_translations
+		WHERE movie_id = $1 AND language = $2
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, movieID, language)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
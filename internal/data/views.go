@@ -0,0 +1,183 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The windows over which trending movies can be ranked: the last 24 hours, counted from
+// the hourly rollups, or the last 7 days, counted from the daily ones.
+var TrendingWindows = []string{"24h", "7d"}
+
+// The trendingViews queries return the number of views of each movie within a trending
+// window. Days are UTC days, as that is how the daily rollups are recorded.
+var trendingViews = map[string]string{
+	"24h": `
+		SELECT movie_id, SUM(views)::bigint AS views
+		FROM movie_views_hourly
+		WHERE hour > NOW() - INTERVAL '24 hours'
+		GROUP BY movie_id`,
+	"7d": `
+		SELECT movie_id, SUM(views)::bigint AS views
+		FROM movie_views_daily
+		WHERE day > (NOW() AT TIME ZONE 'UTC')::date - 7
+		GROUP BY movie_id`,
+}
+
+// The moviePopularity expression counts the views of a movie over the same 7 days as the
+// 7d trending window. Like movieRatingsJoin, it expects the movies table to be in scope.
+const moviePopularity = `COALESCE((
+	SELECT SUM(views)::bigint
+	FROM movie_views_daily
+	WHERE movie_views_daily.movie_id = movies.id AND day > (NOW() AT TIME ZONE 'UTC')::date - 7
+), 0)`
+
+// A TrendingMovie is a movie along with how many times it was viewed within a trending
+// window.
+type TrendingMovie struct {
+	Views int64  `json:"views"`
+	Movie *Movie `json:"movie"`
+}
+
+type ViewModel struct {
+	DB *sql.DB
+}
+
+// Record adds a batch of views, counted by movie ID, to the hourly and daily rollups
+// for the hour and day containing at. Both rollups are updated in a single transaction,
+// so they always agree. Views of movies which have since been purged are dropped.
+func (m ViewModel) Record(views map[int64]int64, at time.Time) error {
+	if len(views) == 0 {
+		return nil
+	}
+
+	movieIDs := make([]int64, 0, len(views))
+	counts := make([]int64, 0, len(views))
+	for movieID, count := range views {
+		movieIDs = append(movieIDs, movieID)
+		counts = append(counts, count)
+	}
+
+	at = at.UTC()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO movie_views_hourly (movie_id, hour, views)
+		SELECT movies.id, $3, batch.views
+		FROM unnest($1::bigint[], $2::bigint[]) AS batch (movie_id, views)
+		INNER JOIN movies ON movies.id = batch.movie_id
+		ON CONFLICT (movie_id, hour) DO UPDATE
+		SET views = movie_views_hourly.views + EXCLUDED.views
+	`, pq.Array(movieIDs), pq.Array(counts), at.Truncate(time.Hour))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO movie_views_daily (movie_id, day, views)
+		SELECT movies.id, $3::date, batch.views
+		FROM unnest($1::bigint[], $2::bigint[]) AS batch (movie_id, views)
+		INNER JOIN movies ON movies.id = batch.movie_id
+		ON CONFLICT (movie_id, day) DO UPDATE
+		SET views = movie_views_daily.views + EXCLUDED.views
+	`, pq.Array(movieIDs), pq.Array(counts), at.Format("2006-01-02"))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// PruneHourly deletes the hourly rollups for hours before the given time, and returns
+// how many were deleted. The daily rollups are kept.
+func (m ViewModel) PruneHourly(before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, `DELETE FROM movie_views_hourly WHERE hour < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// GetTrending returns a page of the most viewed movies within the given window, most
+// viewed first. Movies which weren't viewed at all in the window aren't included. The
+// sort in filters is ignored, as the results are always ordered by views.
+func (m ViewModel) GetTrending(window string, filters Filters) ([]*TrendingMovie, Metadata, error) {
+	views, ok := trendingViews[window]
+	if !ok {
+		return nil, Metadata{}, fmt.Errorf("unknown trending window %q", window)
+	}
+
+	query := fmt.Sprintf(`
+		WITH views AS (%s)
+		SELECT COUNT(*) OVER(), views.views,
+			movies.id, movies.created_at, title, year, runtime, genres, movies.version,
+			COALESCE(ratings.average_rating, 0), COALESCE(ratings.rating_count, 0)
+		FROM views
+		INNER JOIN movies ON movies.id = views.movie_id
+		%s
+		WHERE movies.deleted_at IS NULL
+		ORDER BY views.views DESC, movies.id ASC
+		LIMIT $1 OFFSET $2
+	`, views, movieRatingsJoin)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	var totalRecords int
+	var trending []*TrendingMovie
+
+	for rows.Next() {
+		var item TrendingMovie
+		var movie Movie
+		err := rows.Scan(
+			&totalRecords,
+			&item.Views,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		item.Movie = &movie
+		trending = append(trending, &item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return trending, metadata, nil
+}
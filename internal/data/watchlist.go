@@ -0,0 +1,371 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/startdusk/greenlight/internal/validator"
+)
+
+// Define a custom ErrDuplicateWatchlistItem error, returned when a movie is added to a
+// watchlist that already contains it.
+var (
+	ErrDuplicateWatchlistItem = errors.New("duplicate watchlist item")
+)
+
+type WatchlistItem struct {
+	Position int       `json:"position"` // 1-based position of the movie in the watchlist
+	AddedAt  time.Time `json:"added_at"`
+	Movie    *Movie    `json:"movie"`
+}
+
+type WatchEvent struct {
+	ID        int64     `json:"id"`
+	WatchedAt time.Time `json:"watched_at"`
+	Movie     *Movie    `json:"movie"`
+}
+
+func ValidateWatchedAt(v *validator.Validator, watchedAt time.Time) {
+	v.Check(!watchedAt.After(time.Now()), "watched_at", "must not be in the future")
+}
+
+type WatchlistModel struct {
+	DB *sql.DB
+}
+
+// Insert adds a movie to the end of a user's watchlist.
+func (m WatchlistModel) Insert(userID, movieID int64) (*WatchlistItem, error) {
+	const query = `
+		INSERT INTO watchlist_items (user_id, movie_id, position)
+		SELECT $1, $2, COALESCE(MAX(position), 0) + 1
+		FROM watchlist_items
+		WHERE user_id = $1
+		RETURNING position, added_at
+	`
+
+	item := WatchlistItem{Movie: &Movie{ID: movieID}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	err = lockWatchlist(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRowContext(ctx, query, userID, movieID).Scan(&item.Position, &item.AddedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "watchlist_items_pkey"`:
+			return nil, ErrDuplicateWatchlistItem
+		default:
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+func (m WatchlistModel) GetAllForUser(userID int64, filters Filters) ([]*WatchlistItem, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), watchlist_items.position, watchlist_items.added_at,
+			movies.id, movies.created_at, title, year, runtime, genres, movies.version,
+			COALESCE(ratings.average_rating, 0), COALESCE(ratings.rating_count, 0)
+		FROM watchlist_items
+		INNER JOIN movies ON movies.id = watchlist_items.movie_id
+		%s
+		WHERE watchlist_items.user_id = $1 AND movies.deleted_at IS NULL
+		ORDER BY %s %s, movies.id ASC
+		LIMIT $2 OFFSET $3
+	`, movieRatingsJoin, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	var totalRecords int
+	var items []*WatchlistItem
+
+	for rows.Next() {
+		var item WatchlistItem
+		var movie Movie
+		err := rows.Scan(
+			&totalRecords,
+			&item.Position,
+			&item.AddedAt,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		item.Movie = &movie
+		items = append(items, &item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return items, metadata, nil
+}
+
+// The lockWatchlist() function locks a user's watchlist until the end of the
+// transaction, so that concurrent changes to the positions in the same list are applied
+// one after the other. It locks the user rather than the items, which also covers an
+// empty list. The lock doesn't conflict with rows elsewhere referring to the user.
+func lockWatchlist(ctx context.Context, tx *sql.Tx, userID int64) error {
+	_, err := tx.ExecContext(ctx, `SELECT 1 FROM users WHERE id = $1 FOR NO KEY UPDATE`, userID)
+	return err
+}
+
+// Move changes the position of a movie in a user's watchlist, shifting the movies in
+// between up or down by one place. Positions outside the list are clamped to the first
+// or last place.
+func (m WatchlistModel) Move(userID, movieID int64, position int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	err = lockWatchlist(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+
+	var current, count int
+	err = tx.QueryRowContext(ctx, `
+		SELECT position, (SELECT COUNT(*) FROM watchlist_items WHERE user_id = $1)
+		FROM watchlist_items
+		WHERE user_id = $1 AND movie_id = $2
+	`, userID, movieID).Scan(&current, &count)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if position < 1 {
+		position = 1
+	}
+	if position > count {
+		position = count
+	}
+
+	switch {
+	case position < current:
+		_, err = tx.ExecContext(ctx, `
+			UPDATE watchlist_items SET position = position + 1
+			WHERE user_id = $1 AND position >= $2 AND position < $3
+		`, userID, position, current)
+	case position > current:
+		_, err = tx.ExecContext(ctx, `
+			UPDATE watchlist_items SET position = position - 1
+			WHERE user_id = $1 AND position > $2 AND position <= $3
+		`, userID, current, position)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE watchlist_items SET position = $3
+		WHERE user_id = $1 AND movie_id = $2
+	`, userID, movieID, position)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes a movie from a user's watchlist and closes the gap it leaves behind.
+func (m WatchlistModel) Delete(userID, movieID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	err = lockWatchlist(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+
+	var position int
+	err = tx.QueryRowContext(ctx, `
+		DELETE FROM watchlist_items
+		WHERE user_id = $1 AND movie_id = $2
+		RETURNING position
+	`, userID, movieID).Scan(&position)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE watchlist_items SET position = position - 1
+		WHERE user_id = $1 AND position > $2
+	`, userID, position)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+type WatchHistoryModel struct {
+	DB *sql.DB
+}
+
+func (m WatchHistoryModel) Insert(userID, movieID int64, watchedAt time.Time) (*WatchEvent, error) {
+	const query = `
+		INSERT INTO watch_history (user_id, movie_id, watched_at)
+		VALUES ($1, $2, $3)
+		RETURNING id, watched_at
+	`
+
+	event := WatchEvent{Movie: &Movie{ID: movieID}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID, movieID, watchedAt).Scan(&event.ID, &event.WatchedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &event, nil
+}
+
+func (m WatchHistoryModel) GetAllForUser(userID int64, filters Filters) ([]*WatchEvent, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), watch_history.id, watch_history.watched_at,
+			movies.id, movies.created_at, title, year, runtime, genres, movies.version,
+			COALESCE(ratings.average_rating, 0), COALESCE(ratings.rating_count, 0)
+		FROM watch_history
+		INNER JOIN movies ON movies.id = watch_history.movie_id
+		%s
+		WHERE watch_history.user_id = $1 AND movies.deleted_at IS NULL
+		ORDER BY %s %s, watch_history.id ASC
+		LIMIT $2 OFFSET $3
+	`, movieRatingsJoin, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	var totalRecords int
+	var events []*WatchEvent
+
+	for rows.Next() {
+		var event WatchEvent
+		var movie Movie
+		err := rows.Scan(
+			&totalRecords,
+			&event.ID,
+			&event.WatchedAt,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		event.Movie = &movie
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return events, metadata, nil
+}
+
+func (m WatchHistoryModel) Delete(userID, id int64) error {
+	const query = `
+		DELETE FROM watch_history
+		WHERE id = $1 AND user_id = $2
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
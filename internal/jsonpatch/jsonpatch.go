@@ -0,0 +1,278 @@
+// Package jsonpatch applies RFC 7396 JSON Merge Patch and RFC 6902 JSON Patch
+// documents to JSON values.
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidPatch is returned when a patch document is malformed, for example if
+	// it isn't valid JSON or uses an unsupported operation.
+	ErrInvalidPatch = errors.New("invalid patch document")
+	// ErrPathNotFound is returned when an operation refers to a location which doesn't
+	// exist in the target document.
+	ErrPathNotFound = errors.New("path not found")
+	// ErrTestFailed is returned when a "test" operation doesn't match the target
+	// document.
+	ErrTestFailed = errors.New("test operation failed")
+)
+
+// MergePatch applies a JSON Merge Patch to doc and returns the result. Members of the
+// patch replace the matching members of doc, objects are merged recursively, and a
+// null value removes the member.
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	var target, p any
+
+	err := json.Unmarshal(doc, &target)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(patch, &p)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	return json.Marshal(mergeValue(target, p))
+}
+
+func mergeValue(target, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = make(map[string]any)
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+		targetObject[key] = mergeValue(targetObject[key], value)
+	}
+
+	return targetObject
+}
+
+// An Operation is a single step of a JSON Patch document.
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Apply applies a JSON Patch to doc and returns the result. The add, remove, replace
+// and test operations are supported. The operations are applied in order and, if any
+// of them fails, the error is returned and none of the changes are kept.
+func Apply(doc, patch []byte) ([]byte, error) {
+	var target any
+
+	err := json.Unmarshal(doc, &target)
+	if err != nil {
+		return nil, err
+	}
+
+	var ops []Operation
+	err = json.Unmarshal(patch, &ops)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	for i, op := range ops {
+		target, err = applyOperation(target, op)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+
+	return json.Marshal(target)
+}
+
+func applyOperation(doc any, op Operation) (any, error) {
+	tokens, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value any
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, fmt.Errorf("%w: %q operation is missing a value", ErrInvalidPatch, op.Op)
+		}
+		err = json.Unmarshal(op.Value, &value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+		}
+	case "remove":
+	default:
+		return nil, fmt.Errorf("%w: unsupported operation %q", ErrInvalidPatch, op.Op)
+	}
+
+	switch op.Op {
+	case "test":
+		current, err := get(doc, tokens)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, value) {
+			return nil, fmt.Errorf("%w: value at %q doesn't match", ErrTestFailed, op.Path)
+		}
+		return doc, nil
+	default:
+		return update(doc, tokens, op.Op, value)
+	}
+}
+
+// The parsePointer() function splits a JSON Pointer (RFC 6901) into its unescaped
+// reference tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: path %q must start with \"/\"", ErrInvalidPatch, pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i := range tokens {
+		tokens[i] = strings.ReplaceAll(tokens[i], "~1", "/")
+		tokens[i] = strings.ReplaceAll(tokens[i], "~0", "~")
+	}
+
+	return tokens, nil
+}
+
+// The arrayIndex() function converts a reference token into an index of an array with
+// the given length. With allowEnd set to true the index can be one past the last
+// element, which is also what the "-" token refers to.
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if token == "-" && allowEnd {
+		return length, nil
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrPathNotFound, token)
+	}
+
+	if i > length || (i == length && !allowEnd) {
+		return 0, fmt.Errorf("%w: array index %d is out of range", ErrPathNotFound, i)
+	}
+
+	return i, nil
+}
+
+func get(doc any, tokens []string) (any, error) {
+	for _, token := range tokens {
+		switch node := doc.(type) {
+		case map[string]any:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("%w: member %q doesn't exist", ErrPathNotFound, token)
+			}
+			doc = value
+		case []any:
+			i, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, fmt.Errorf("%w: %q can't be looked up in a scalar value", ErrPathNotFound, token)
+		}
+	}
+
+	return doc, nil
+}
+
+// The update() function carries out an add, remove or replace operation at the location
+// given by tokens, and returns the updated document. Containers are modified in place,
+// except for arrays which may have to be reallocated.
+func update(doc any, tokens []string, op string, value any) (any, error) {
+	if len(tokens) == 0 {
+		switch op {
+		case "remove":
+			return nil, fmt.Errorf("%w: the whole document can't be removed", ErrInvalidPatch)
+		default:
+			return value, nil
+		}
+	}
+
+	token := tokens[0]
+
+	switch node := doc.(type) {
+	case map[string]any:
+		current, ok := node[token]
+		if len(tokens) > 1 {
+			if !ok {
+				return nil, fmt.Errorf("%w: member %q doesn't exist", ErrPathNotFound, token)
+			}
+			child, err := update(current, tokens[1:], op, value)
+			if err != nil {
+				return nil, err
+			}
+			node[token] = child
+			return node, nil
+		}
+
+		switch op {
+		case "add":
+			node[token] = value
+		case "remove", "replace":
+			if !ok {
+				return nil, fmt.Errorf("%w: member %q doesn't exist", ErrPathNotFound, token)
+			}
+			if op == "remove" {
+				delete(node, token)
+			} else {
+				node[token] = value
+			}
+		}
+		return node, nil
+
+	case []any:
+		if len(tokens) > 1 {
+			i, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			child, err := update(node[i], tokens[1:], op, value)
+			if err != nil {
+				return nil, err
+			}
+			node[i] = child
+			return node, nil
+		}
+
+		i, err := arrayIndex(token, len(node), op == "add")
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case "add":
+			node = append(node, nil)
+			copy(node[i+1:], node[i:])
+			node[i] = value
+		case "remove":
+			node = append(node[:i], node[i+1:]...)
+		case "replace":
+			node[i] = value
+		}
+		return node, nil
+
+	default:
+		return nil, fmt.Errorf("%w: %q can't be looked up in a scalar value", ErrPathNotFound, token)
+	}
+}
@@ -0,0 +1,126 @@
+// Package storage provides somewhere to keep uploaded files, behind an interface so
+// that the backend can be swapped out.
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotFound is returned when there is no file stored under a key.
+var ErrNotFound = errors.New("storage: file not found")
+
+// An Object is a stored file opened for reading.
+type Object interface {
+	io.ReadSeekCloser
+}
+
+// A Storage holds files under slash-separated keys, such as "posters/1/original".
+type Storage interface {
+	// Put stores the contents of r under key, replacing any existing file. Readers
+	// never see a partly written file.
+	Put(key string, r io.Reader) error
+	// Get opens the file stored under key.
+	Get(key string) (Object, error)
+	// Delete removes the file stored under key. Deleting a key which doesn't exist is
+	// not an error.
+	Delete(key string) error
+}
+
+// Filesystem is a Storage which keeps files in a directory on the local disk.
+type Filesystem struct {
+	root string
+}
+
+// NewFilesystem returns a Filesystem storing files under the root directory, which is
+// created if it doesn't exist.
+func NewFilesystem(root string) (*Filesystem, error) {
+	err := os.MkdirAll(root, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Filesystem{root: root}, nil
+}
+
+// The path() method converts a key into a path inside the root directory, rejecting
+// keys which would escape it.
+func (fs *Filesystem) path(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, `\`) {
+		return "", errors.New("storage: invalid key " + key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", errors.New("storage: invalid key " + key)
+		}
+	}
+
+	return filepath.Join(fs.root, filepath.FromSlash(key)), nil
+}
+
+func (fs *Filesystem) Put(key string, r io.Reader) error {
+	path, err := fs.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file in the same directory and rename it into place, so the
+	// file is replaced in one step.
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (fs *Filesystem) Get(key string) (Object, error) {
+	path, err := fs.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (fs *Filesystem) Delete(key string) error {
+	path, err := fs.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,15 @@
+CREATE TABLE
+    IF NOT EXISTS reviews (
+        id BIGSERIAL PRIMARY KEY,
+        created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+        movie_id BIGINT NOT NULL REFERENCES movies ON DELETE CASCADE,
+        user_id BIGINT NOT NULL REFERENCES users ON DELETE CASCADE,
+        rating INTEGER NOT NULL,
+        body TEXT NOT NULL DEFAULT '',
+        version INTEGER NOT NULL DEFAULT 1,
+        CONSTRAINT reviews_movie_id_user_id_key UNIQUE (movie_id, user_id)
+    );
+
+ALTER TABLE reviews
+ADD
+    CONSTRAINT reviews_rating_check CHECK (rating BETWEEN 1 AND 10);
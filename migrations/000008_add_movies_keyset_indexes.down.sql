@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS movies_title_id_idx;
+
+DROP INDEX IF EXISTS movies_year_id_idx;
+
+DROP INDEX IF EXISTS movies_runtime_id_idx;
@@ -0,0 +1,25 @@
+CREATE TABLE
+    IF NOT EXISTS people (
+        id BIGSERIAL PRIMARY KEY,
+        created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+        name TEXT NOT NULL,
+        birth_year INTEGER NOT NULL DEFAULT 0,
+        version INTEGER NOT NULL DEFAULT 1
+    );
+
+CREATE TABLE
+    IF NOT EXISTS movie_credits (
+        movie_id BIGINT NOT NULL REFERENCES movies ON DELETE CASCADE,
+        person_id BIGINT NOT NULL REFERENCES people ON DELETE CASCADE,
+        role TEXT NOT NULL,
+        character TEXT NOT NULL DEFAULT '',
+        billing_order INTEGER NOT NULL DEFAULT 0,
+        PRIMARY KEY (movie_id, person_id, role)
+    );
+
+ALTER TABLE movie_credits
+ADD
+    CONSTRAINT movie_credits_role_check CHECK (role IN ('director', 'actor', 'writer'));
+
+CREATE INDEX IF NOT EXISTS people_name_idx ON people USING GIN (to_tsvector('simple', name));
+CREATE INDEX IF NOT EXISTS movie_credits_person_id_idx ON movie_credits (person_id);
@@ -0,0 +1,5 @@
+DELETE FROM permissions WHERE code = 'watchlist:write';
+
+DROP TABLE IF EXISTS watch_history;
+
+DROP TABLE IF EXISTS watchlist_items;
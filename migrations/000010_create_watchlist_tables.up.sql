@@ -0,0 +1,27 @@
+CREATE TABLE
+    IF NOT EXISTS watchlist_items (
+        user_id BIGINT NOT NULL REFERENCES users ON DELETE CASCADE,
+        movie_id BIGINT NOT NULL REFERENCES movies ON DELETE CASCADE,
+        added_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+        position INTEGER NOT NULL,
+        PRIMARY KEY (user_id, movie_id)
+    );
+
+CREATE TABLE
+    IF NOT EXISTS watch_history (
+        id BIGSERIAL PRIMARY KEY,
+        user_id BIGINT NOT NULL REFERENCES users ON DELETE CASCADE,
+        movie_id BIGINT NOT NULL REFERENCES movies ON DELETE CASCADE,
+        watched_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+    );
+
+CREATE INDEX IF NOT EXISTS watch_history_user_id_idx ON watch_history (user_id, watched_at);
+
+-- Add the new permission and grant it to every existing user.
+
+INSERT INTO permissions (code) VALUES ('watchlist:write');
+
+INSERT INTO users_permissions
+SELECT users.id, permissions.id
+FROM users, permissions
+WHERE permissions.code = 'watchlist:write';
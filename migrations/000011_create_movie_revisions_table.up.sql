@@ -0,0 +1,20 @@
+CREATE TABLE
+    IF NOT EXISTS movie_revisions (
+        movie_id BIGINT NOT NULL REFERENCES movies ON DELETE CASCADE,
+        version INTEGER NOT NULL,
+        created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+        user_id BIGINT REFERENCES users ON DELETE SET NULL,
+        title TEXT NOT NULL,
+        year INTEGER NOT NULL,
+        runtime INTEGER NOT NULL,
+        genres TEXT[] NOT NULL,
+        changes JSONB NOT NULL DEFAULT '{}',
+        PRIMARY KEY (movie_id, version)
+    );
+
+-- Record the current state of every existing movie as its first known revision. The
+-- acting user for these is unknown.
+
+INSERT INTO movie_revisions (movie_id, version, created_at, title, year, runtime, genres)
+SELECT id, version, created_at, title, year, runtime, genres
+FROM movies;
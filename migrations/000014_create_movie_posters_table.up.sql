@@ -0,0 +1,11 @@
+CREATE TABLE
+    IF NOT EXISTS movie_posters (
+        movie_id BIGINT PRIMARY KEY REFERENCES movies ON DELETE CASCADE,
+        uploaded_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+        content_type TEXT NOT NULL,
+        width INTEGER NOT NULL,
+        height INTEGER NOT NULL,
+        size BIGINT NOT NULL,
+        version INTEGER NOT NULL DEFAULT 1,
+        storage_key TEXT NOT NULL
+    );
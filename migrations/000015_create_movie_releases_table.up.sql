@@ -0,0 +1,17 @@
+CREATE TABLE
+    IF NOT EXISTS movie_releases (
+        movie_id BIGINT NOT NULL REFERENCES movies ON DELETE CASCADE,
+        country CHAR(2) NOT NULL,
+        release_type TEXT NOT NULL,
+        release_date DATE NOT NULL,
+        certification TEXT NOT NULL DEFAULT '',
+        PRIMARY KEY (movie_id, country, release_type)
+    );
+
+ALTER TABLE movie_releases
+ADD
+    CONSTRAINT movie_releases_release_type_check CHECK (
+        release_type IN ('theatrical', 'digital', 'physical', 'tv')
+    );
+
+CREATE INDEX IF NOT EXISTS movie_releases_country_release_date_idx ON movie_releases (country, release_date);
@@ -0,0 +1,14 @@
+CREATE TABLE
+    IF NOT EXISTS movie_translations (
+        movie_id BIGINT NOT NULL REFERENCES movies ON DELETE CASCADE,
+        language TEXT NOT NULL,
+        title TEXT NOT NULL,
+        overview TEXT NOT NULL DEFAULT '',
+        tagline TEXT NOT NULL DEFAULT '',
+        version INTEGER NOT NULL DEFAULT 1,
+        PRIMARY KEY (movie_id, language)
+    );
+
+CREATE INDEX IF NOT EXISTS movie_translations_title_idx ON movie_translations USING GIN (to_tsvector('simple', title));
+
+CREATE INDEX IF NOT EXISTS movie_translations_title_trgm_idx ON movie_translations USING GIN (title gin_trgm_ops);
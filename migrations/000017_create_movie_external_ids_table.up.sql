@@ -0,0 +1,17 @@
+CREATE TABLE
+    IF NOT EXISTS movie_external_ids (
+        movie_id BIGINT NOT NULL REFERENCES movies ON DELETE CASCADE,
+        provider TEXT NOT NULL,
+        value TEXT NOT NULL,
+        PRIMARY KEY (movie_id, provider)
+    );
+
+ALTER TABLE movie_external_ids
+ADD
+    CONSTRAINT movie_external_ids_provider_check CHECK (
+        provider IN ('imdb', 'tmdb', 'eidr')
+    );
+
+ALTER TABLE movie_external_ids
+ADD
+    CONSTRAINT movie_external_ids_provider_value_key UNIQUE (provider, value);
@@ -0,0 +1,5 @@
+DELETE FROM permissions WHERE code = 'collections:admin';
+
+DROP TABLE IF EXISTS collection_items;
+
+DROP TABLE IF EXISTS collections;
@@ -0,0 +1,33 @@
+CREATE TABLE
+    IF NOT EXISTS collections (
+        id BIGSERIAL PRIMARY KEY,
+        created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+        user_id BIGINT NOT NULL REFERENCES users ON DELETE CASCADE,
+        name TEXT NOT NULL,
+        description TEXT NOT NULL DEFAULT '',
+        visibility TEXT NOT NULL DEFAULT 'private',
+        version INTEGER NOT NULL DEFAULT 1
+    );
+
+ALTER TABLE collections
+ADD
+    CONSTRAINT collections_visibility_check CHECK (
+        visibility IN ('public', 'private')
+    );
+
+CREATE INDEX IF NOT EXISTS collections_user_id_idx ON collections (user_id);
+
+CREATE TABLE
+    IF NOT EXISTS collection_items (
+        collection_id BIGINT NOT NULL REFERENCES collections ON DELETE CASCADE,
+        movie_id BIGINT NOT NULL REFERENCES movies ON DELETE CASCADE,
+        added_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+        position INTEGER NOT NULL,
+        PRIMARY KEY (collection_id, movie_id)
+    );
+
+CREATE INDEX IF NOT EXISTS collection_items_movie_id_idx ON collection_items (movie_id);
+
+-- Add the new permission. It isn't granted to anyone by default.
+
+INSERT INTO permissions (code) VALUES ('collections:admin');
@@ -0,0 +1,5 @@
+DELETE FROM permissions WHERE code = 'tags:moderate';
+
+DROP TABLE IF EXISTS banned_tags;
+
+DROP TABLE IF EXISTS movie_tags;
@@ -0,0 +1,24 @@
+CREATE TABLE
+    IF NOT EXISTS movie_tags (
+        movie_id BIGINT NOT NULL REFERENCES movies ON DELETE CASCADE,
+        user_id BIGINT NOT NULL REFERENCES users ON DELETE CASCADE,
+        tag TEXT NOT NULL,
+        created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+        PRIMARY KEY (movie_id, user_id, tag)
+    );
+
+CREATE INDEX IF NOT EXISTS movie_tags_tag_idx ON movie_tags (tag, movie_id);
+
+CREATE INDEX IF NOT EXISTS movie_tags_user_id_idx ON movie_tags (user_id);
+
+CREATE TABLE
+    IF NOT EXISTS banned_tags (
+        tag TEXT PRIMARY KEY,
+        banned_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+        banned_by BIGINT REFERENCES users ON DELETE SET NULL,
+        reason TEXT NOT NULL DEFAULT ''
+    );
+
+-- Add the new permission. It isn't granted to anyone by default.
+
+INSERT INTO permissions (code) VALUES ('tags:moderate');
@@ -0,0 +1,7 @@
+CREATE TABLE
+    IF NOT EXISTS movie_similarities (
+        movie_id BIGINT NOT NULL REFERENCES movies ON DELETE CASCADE,
+        similar_movie_id BIGINT NOT NULL REFERENCES movies ON DELETE CASCADE,
+        score DOUBLE PRECISION NOT NULL,
+        PRIMARY KEY (movie_id, similar_movie_id)
+    );
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS movie_views_daily;
+
+DROP TABLE IF EXISTS movie_views_hourly;
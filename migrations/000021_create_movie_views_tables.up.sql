@@ -0,0 +1,19 @@
+CREATE TABLE
+    IF NOT EXISTS movie_views_hourly (
+        movie_id BIGINT NOT NULL REFERENCES movies ON DELETE CASCADE,
+        hour TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+        views BIGINT NOT NULL,
+        PRIMARY KEY (movie_id, hour)
+    );
+
+CREATE INDEX IF NOT EXISTS movie_views_hourly_hour_idx ON movie_views_hourly (hour);
+
+CREATE TABLE
+    IF NOT EXISTS movie_views_daily (
+        movie_id BIGINT NOT NULL REFERENCES movies ON DELETE CASCADE,
+        day DATE NOT NULL,
+        views BIGINT NOT NULL,
+        PRIMARY KEY (movie_id, day)
+    );
+
+CREATE INDEX IF NOT EXISTS movie_views_daily_day_idx ON movie_views_daily (day);